@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// resetter is a store that can go back to its starting data
+type resetter interface {
+	Reset() error
+}
+
+// resetData wipes posts, comments and revisions and reseeds the sample posts,
+// or the -seed file's, so end-to-end tests can start from a known state.
+// Only routed with -dev.
+func (a *api) resetData(w http.ResponseWriter, r *http.Request) {
+	stores := []any{a.store, a.comments, a.revisions}
+	// Only a seeder gets a seed, see seedStore
+	if a.seed != nil {
+		if err := a.store.(seeder).Seed(r.Context(), a.seed); err != nil {
+			slog.ErrorContext(r.Context(), "resetting data", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Error resetting data")
+			return
+		}
+		stores = stores[1:]
+	}
+	for _, store := range stores {
+		rs, ok := store.(resetter)
+		if !ok {
+			continue
+		}
+		if err := rs.Reset(); err != nil {
+			slog.ErrorContext(r.Context(), "resetting data", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "Error resetting data")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
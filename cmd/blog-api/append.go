@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// appendRequest is the body of POST /posts/{id}/append
+type appendRequest struct {
+	Content string `json:"content"`
+}
+
+// appendPost adds {"content":"..."} on a new line at the end of a post, for
+// live-blogging without sending the whole post back. The store does the
+// append, so two updates posted at once both end up in the post.
+func (a *api) appendPost(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	var body appendRequest
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Content) == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidFields, "Content to append is required")
+		return
+	}
+	if errs := a.checkFields(nil, &body.Content, nil, "can't be empty"); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	current, ok := a.findPost(r.Context(), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	// Checked against what's there now, an append racing this one can still go a little over
+	if limit := a.cfg.maxContent; limit > 0 && utf8.RuneCountInString(joinContent(current.Content, body.Content)) > limit {
+		writeFieldErrors(w, []FieldError{{Field: "content", Message: fmt.Sprintf("must be at most %d characters once appended", limit)}})
+		return
+	}
+
+	post, err := a.store.AppendContent(r.Context(), id, body.Content)
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	a.saveRevision(current, post)
+	a.emit(r, eventUpdated, post)
+
+	setETag(w, post)
+	writeJSON(w, r, http.StatusOK, post)
+}
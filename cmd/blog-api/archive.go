@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// archiveMonth is one entry of GET /posts/archive
+type archiveMonth struct {
+	Year  int `json:"year"`
+	Month int `json:"month"`
+	Count int `json:"count"`
+}
+
+// getArchive counts published posts by the month they were created in, newest
+// month first. Months without posts are left out rather than listed with 0.
+func (a *api) getArchive(w http.ResponseWriter, r *http.Request) {
+	counts := map[archiveMonth]int{}
+	for _, post := range a.store.List(r.Context()) {
+		if post.Published && !post.Deleted {
+			created := post.CreatedAt.UTC()
+			counts[archiveMonth{Year: created.Year(), Month: int(created.Month())}]++
+		}
+	}
+
+	months := make([]archiveMonth, 0, len(counts))
+	for month, n := range counts {
+		month.Count = n
+		months = append(months, month)
+	}
+	sort.Slice(months, func(i, j int) bool {
+		if months[i].Year != months[j].Year {
+			return months[i].Year > months[j].Year
+		}
+		return months[i].Month > months[j].Month
+	})
+
+	writeJSON(w, r, http.StatusOK, months)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// attachmentTypes are the images posts can have and the extension each is stored
+// under. SVG isn't one of them, it can carry script.
+var attachmentTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// maxFilename is the longest original filename kept, in characters
+const maxFilename = 255
+
+// uploadName is what a stored file is called, a random UUID and the type's extension
+var uploadName = regexp.MustCompile(`^[0-9a-f-]{36}\.(png|jpg|gif|webp)$`)
+
+// Attachment is an image uploaded to a post. The file is on disk, the post
+// only keeps this.
+type Attachment struct {
+	ID          string    `json:"id" xml:"id"`
+	Filename    string    `json:"filename" xml:"filename"`
+	URL         string    `json:"url" xml:"url"`
+	Size        int64     `json:"size" xml:"size"`
+	ContentType string    `json:"content_type" xml:"content_type"`
+	CreatedAt   time.Time `json:"created_at" xml:"created_at"`
+}
+
+// isUpload reports whether the request is a file upload to an attachments route
+func isUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data" && strings.HasSuffix(r.URL.Path, "/attachments")
+}
+
+// getAttachments lists the images attached to a post, oldest first
+func (a *api) getAttachments(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	post, ok := a.findVisiblePost(r, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	attachments := post.Attachments
+	if attachments == nil {
+		attachments = []Attachment{}
+	}
+	writeJSON(w, r, http.StatusOK, attachments)
+}
+
+// uploadAttachment stores the image in the "file" field of a multipart form and
+// records it on the post. The type is sniffed from the file itself, what the
+// client claims isn't trusted, and anything but an image is a 415.
+func (a *api) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+	if _, ok := a.findPost(r.Context(), id); !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	// limitBody leaves uploads alone, leave room for the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, a.cfg.maxUpload+1<<16)
+	file, err := formFile(r, "file")
+	if err != nil {
+		writeUploadError(w, err, a.cfg.maxUpload)
+		return
+	}
+
+	// The first 512 bytes are all DetectContentType looks at
+	data := bufio.NewReaderSize(file, 512)
+	head, _ := data.Peek(512)
+	contentType := http.DetectContentType(head)
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupported, "Only PNG, JPEG, GIF and WebP images can be attached, got "+contentType)
+		return
+	}
+
+	att := Attachment{
+		ID:          uuid.NewString(),
+		Filename:    a.attachmentFilename(file.FileName()),
+		ContentType: contentType,
+		CreatedAt:   time.Now().UTC(),
+	}
+	name := att.ID + ext
+	att.URL = "/uploads/" + name
+	att.Size, err = a.saveUpload(name, data)
+	if err != nil {
+		writeUploadError(w, err, a.cfg.maxUpload)
+		return
+	}
+
+	post, err := a.store.AddAttachment(r.Context(), id, att)
+	if err != nil {
+		os.Remove(filepath.Join(a.cfg.uploadDir, name))
+		writeUpdateError(w, r, err)
+		return
+	}
+	a.emit(r, eventUpdated, post)
+
+	w.Header().Set("Location", baseURL(r)+att.URL)
+	writeJSON(w, r, http.StatusCreated, att)
+}
+
+// errTooLarge is a file over -max-upload
+var errTooLarge = errors.New("file too large")
+
+// errNoFile is a form without the file field
+var errNoFile = errors.New("no file")
+
+// formFile finds the named file part of a multipart form, reading the parts as
+// they stream in so the file is never held in memory
+func formFile(r *http.Request, field string) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errNoFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+// saveUpload writes the file to the upload directory, through a temp file so
+// a failed or oversized upload leaves nothing behind
+func (a *api) saveUpload(name string, src io.Reader) (int64, error) {
+	if err := os.MkdirAll(a.cfg.uploadDir, 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(a.cfg.uploadDir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(src, a.cfg.maxUpload+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n > a.cfg.maxUpload {
+		return 0, errTooLarge
+	}
+	return n, os.Rename(tmp.Name(), filepath.Join(a.cfg.uploadDir, name))
+}
+
+// attachmentFilename is the client's name for the file, without any directories
+// and cleaned like the rest of a post's text
+func (a *api) attachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if utf8.RuneCountInString(name) > maxFilename {
+		name = string([]rune(name)[:maxFilename])
+	}
+	if a.sanitize != nil {
+		name = a.sanitize(name)
+	}
+	return name
+}
+
+// writeUploadError maps what can go wrong reading an upload to a response
+func writeUploadError(w http.ResponseWriter, err error, limit int64) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, errTooLarge) || errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("Attachments can be at most %d bytes", limit))
+	case errors.Is(err, errNoFile):
+		writeError(w, http.StatusBadRequest, codeInvalidFields, `The image goes in a "file" field`)
+	case errors.Is(err, http.ErrNotMultipart) || errors.Is(err, http.ErrMissingBoundary):
+		writeError(w, http.StatusBadRequest, codeInvalidFields, "Uploads must be multipart/form-data")
+	default:
+		slog.Error("saving attachment", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error saving attachment")
+	}
+}
+
+// removeAttachments deletes a post's files once the post itself is gone for good
+func (a *api) removeAttachments(post Post) {
+	for _, att := range post.Attachments {
+		name := filepath.Base(att.URL)
+		if err := os.Remove(filepath.Join(a.cfg.uploadDir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("removing attachment", "post", post.ID, "file", name, "err", err)
+		}
+	}
+}
+
+// serveUpload serves a stored attachment. Only names the server generated are
+// looked up, so the path can't reach outside the upload directory.
+func (a *api) serveUpload(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !uploadName.MatchString(name) {
+		notFound(w, r)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(a.cfg.uploadDir, name))
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		notFound(w, r)
+		return
+	}
+
+	// Replaces the JSON default, ServeContent handles ranges and If-Modified-Since
+	w.Header().Set("Content-Type", mime.TypeByExtension(filepath.Ext(name)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// auditPurged is logged when a post already in the trash is deleted for
+	// good, subscribers heard about it going to the trash so it isn't an event
+	auditPurged = "post.purged"
+
+	// auditRestored is logged when POST /admin/restore replaces everything, with no post ID
+	auditRestored = "store.restored"
+
+	// maxAuditEntries is how many entries are kept in memory for /admin/audit,
+	// older ones are only in the -audit-log file
+	maxAuditEntries = 10_000
+
+	// auditBuffer is how many entries can wait for the file writer before
+	// requests have to wait for it
+	auditBuffer = 1024
+)
+
+// auditEntry is one change to a post and who made it
+type auditEntry struct {
+	ID        int       `json:"id"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	PostID    int       `json:"post_id"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// auditPage is a page of the audit log, newest entries first
+type auditPage struct {
+	Data   []auditEntry `json:"data"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+// auditLog is an append-only record of every change. Entries are kept in
+// memory for /admin/audit straight away, and written to the file, if any,
+// by a background goroutine so requests don't wait on the disk.
+type auditLog struct {
+	actor      string
+	trustProxy bool
+
+	mu      sync.Mutex
+	entries []auditEntry
+	nextID  int
+
+	// file and its writer are nil when the log is only kept in memory.
+	// closed is set by close, under mu so nothing is sent to a closed pending.
+	file    *os.File
+	pending chan auditEntry
+	done    chan struct{}
+	closed  bool
+}
+
+// newAuditLog starts an audit log, appending to the file at path when it's
+// set and picking up the entries already in it
+func newAuditLog(path, apiKey string, trustProxy bool) (*auditLog, error) {
+	l := &auditLog{actor: auditActor(apiKey), trustProxy: trustProxy, nextID: 1}
+	if path == "" {
+		return l, nil
+	}
+	if err := l.load(path); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	l.pending = make(chan auditEntry, auditBuffer)
+	l.done = make(chan struct{})
+	go l.run()
+	return l, nil
+}
+
+// auditActor names whoever the API key lets write. Only a fingerprint of the
+// key is logged, so reading the log doesn't give the key away.
+func auditActor(apiKey string) string {
+	if apiKey == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key:" + hex.EncodeToString(sum[:4])
+}
+
+// load reads the entries an earlier run left in the file, a missing file is an empty log
+func (l *auditLog) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		l.append(e)
+		l.nextID = e.ID + 1
+	}
+	return scanner.Err()
+}
+
+// append adds to the entries in memory, dropping the oldest past maxAuditEntries
+func (l *auditLog) append(e auditEntry) {
+	l.entries = append(l.entries, e)
+	if len(l.entries) > maxAuditEntries {
+		l.entries = l.entries[len(l.entries)-maxAuditEntries:]
+	}
+}
+
+// record logs the action on the post by whoever sent the request. A nil log
+// records nothing, a closed one only keeps the entry in memory: shutting down
+// can give up on requests that are still running.
+func (l *auditLog) record(r *http.Request, action string, postID int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := auditEntry{
+		ID:        l.nextID,
+		Time:      time.Now().UTC(),
+		Action:    action,
+		PostID:    postID,
+		Actor:     l.actor,
+		IP:        clientIP(r, l.trustProxy),
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	l.nextID++
+	l.append(e)
+
+	// Only waits when the writer is a whole buffer behind, an entry is never dropped.
+	// The writer doesn't take the lock, so holding it here only keeps entries in order.
+	if l.pending != nil && !l.closed {
+		l.pending <- e
+	}
+}
+
+// run writes entries to the file as JSON lines until close
+func (l *auditLog) run() {
+	defer close(l.done)
+
+	enc := json.NewEncoder(l.file)
+	for e := range l.pending {
+		if err := enc.Encode(e); err != nil {
+			slog.Error("writing audit log", "err", err, "id", e.ID)
+		}
+	}
+}
+
+// close writes what's still pending and closes the file. Entries recorded
+// after it aren't written.
+func (l *auditLog) close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.pending)
+	l.mu.Unlock()
+
+	<-l.done
+	return l.file.Close()
+}
+
+// page returns limit entries, newest first, after skipping offset of them
+func (l *auditLog) page(limit, offset int) auditPage {
+	p := auditPage{Data: []auditEntry{}, Limit: limit, Offset: offset}
+	if l == nil {
+		return p
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p.Total = len(l.entries)
+	for i := len(l.entries) - 1 - offset; i >= 0 && len(p.Data) < limit; i-- {
+		p.Data = append(p.Data, l.entries[i])
+	}
+	return p
+}
+
+// getAudit lists the audit log, paged with ?limit= and ?offset= like posts
+func (a *api) getAudit(w http.ResponseWriter, r *http.Request) {
+	limit := queryInt(r, "limit", defaultLimit)
+	if limit == 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	writeJSON(w, r, http.StatusOK, a.audit.page(limit, queryInt(r, "offset", 0)))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// authorCount is one entry of GET /authors
+type authorCount struct {
+	Author string `json:"author"`
+	Count  int    `json:"count"`
+}
+
+// allowAuthor checks the author against the configured allow-list, ignoring
+// case, and swaps in the list's spelling. Without a list any author goes.
+func (a *api) allowAuthor(author *string) bool {
+	if len(a.cfg.authors) == 0 {
+		return true
+	}
+	for _, allowed := range a.cfg.authors {
+		// The author is sanitized by now, and the list's spelling has to be too
+		if strings.EqualFold(plainText(*author), allowed) {
+			*author = allowed
+			if a.sanitize != nil {
+				*author = a.sanitize(allowed)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// getAuthors lists everyone with published posts and how many they have,
+// most posts first. Drafts and the trash don't count.
+func (a *api) getAuthors(w http.ResponseWriter, r *http.Request) {
+	counts := map[string]int{}
+	for _, post := range a.store.List(r.Context()) {
+		if post.Published && !post.Deleted {
+			counts[post.Author]++
+		}
+	}
+
+	authors := make([]authorCount, 0, len(counts))
+	for author, n := range counts {
+		authors = append(authors, authorCount{Author: author, Count: n})
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if authors[i].Count != authors[j].Count {
+			return authors[i].Count > authors[j].Count
+		}
+		return authors[i].Author < authors[j].Author
+	})
+
+	writeJSON(w, r, http.StatusOK, authors)
+}
+
+// getAuthorPosts is the post list narrowed down to one author, ?author= with
+// the name in the path, so paging, sorting and the other filters all work.
+// With an allow-list configured, authors not on it are a 404.
+func (a *api) getAuthorPosts(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	// chi matches on the raw path when it has escapes that decoding would lose
+	if r.URL.RawPath != "" {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+	}
+
+	if len(a.cfg.authors) > 0 && !slices.ContainsFunc(a.cfg.authors, func(allowed string) bool {
+		return strings.EqualFold(allowed, name)
+	}) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Author not found")
+		return
+	}
+
+	r = r.Clone(r.Context())
+	q := r.URL.Query()
+	q.Set("author", name)
+	r.URL.RawQuery = q.Encode()
+	a.getPosts(w, r)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// backupFormat is bumped whenever the backup document changes shape
+const backupFormat = 1
+
+// backup is everything /admin/restore needs to put the blog back as it was.
+// Likes and views are counters on the posts themselves.
+type backup struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	Posts     []Post    `json:"posts"`
+	Comments  []Comment `json:"comments"`
+}
+
+// restorer is a store that can take back a backup's posts exactly as they were
+type restorer interface {
+	Restore(ctx context.Context, posts []Post) error
+}
+
+// commentRestorer is restorer for comments
+type commentRestorer interface {
+	Restore(comments []Comment)
+}
+
+// isRestore reports whether the request is a backup sent to /admin/restore
+func isRestore(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.URL.Path == "/admin/restore"
+}
+
+// getBackup downloads every post, trash included, and every comment as one document
+func (a *api) getBackup(w http.ResponseWriter, r *http.Request) {
+	b := backup{Format: backupFormat, CreatedAt: time.Now().UTC(), Posts: a.store.List(r.Context()), Comments: []Comment{}}
+	for _, post := range b.Posts {
+		b.Comments = append(b.Comments, a.comments.List(post.ID)...)
+	}
+
+	name := "blog-backup-" + b.CreatedAt.Format("20060102T150405Z") + ".json"
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	writeJSON(w, r, http.StatusOK, b)
+}
+
+// restoreBackup replaces posts and comments with a backup's. The whole document
+// is checked first, so a bad one gets a 422 and changes nothing.
+func (a *api) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	posts, postsOK := a.store.(restorer)
+	comments, commentsOK := a.comments.(commentRestorer)
+	if !postsOK || !commentsOK {
+		writeError(w, http.StatusInternalServerError, codeInternal, "This store can't be restored")
+		return
+	}
+
+	// limitBody leaves restores alone, a backup is the whole blog
+	if a.cfg.maxRestore > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, a.cfg.maxRestore)
+	}
+	var b backup
+	if !decodeJSON(w, r, &b) {
+		return
+	}
+	if errs := a.checkBackup(&b); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	if err := posts.Restore(r.Context(), b.Posts); err != nil {
+		slog.ErrorContext(r.Context(), "restoring backup", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error restoring backup")
+		return
+	}
+	comments.Restore(b.Comments)
+	// Revisions aren't backed up, and they'd describe the posts that were replaced
+	if rs, ok := a.revisions.(resetter); ok {
+		rs.Reset()
+	}
+	a.audit.record(r, auditRestored, 0)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkBackup finds everything that would stop a backup from being restored
+// as it was: IDs, UUIDs and slugs must be unique and every comment's post there.
+// A backup is only a file someone sends, so posts' text is sanitized like a new
+// post's. Lengths and authors aren't checked again, they may have changed since.
+func (a *api) checkBackup(b *backup) []FieldError {
+	var errs []FieldError
+	if b.Format != backupFormat {
+		errs = append(errs, FieldError{Field: "format", Message: fmt.Sprintf("must be %d", backupFormat)})
+	}
+
+	ids, uuids, slugs := map[int]bool{}, map[string]bool{}, map[string]bool{}
+	for i := range b.Posts {
+		p := &b.Posts[i]
+		// Sanitized before the empty checks, so markup alone doesn't count
+		if a.sanitize != nil {
+			p.Title, p.Content, p.Author = a.sanitize(p.Title), a.sanitize(p.Content), a.sanitize(p.Author)
+		}
+		field := func(name, msg string) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("posts.%d.%s", i, name), Message: msg})
+		}
+		switch {
+		case p.ID <= 0:
+			field("id", "must be positive")
+		case ids[p.ID]:
+			field("id", "is used by another post")
+		}
+		switch {
+		case p.UUID == "":
+			field("uuid", "required")
+		case uuids[p.UUID]:
+			field("uuid", "is used by another post")
+		}
+		if p.Slug != "" && slugs[p.Slug] {
+			field("slug", "is used by another post")
+		}
+		if p.Title == "" {
+			field("title", "required")
+		}
+		if p.Content == "" {
+			field("content", "required")
+		}
+		if p.Author == "" {
+			field("author", "required")
+		}
+		ids[p.ID], uuids[p.UUID], slugs[p.Slug] = true, true, true
+	}
+
+	// Comments come in creation order, so a parent is always seen before its replies
+	commentPosts := map[int]int{}
+	for i, c := range b.Comments {
+		field := func(name, msg string) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("comments.%d.%s", i, name), Message: msg})
+		}
+		_, taken := commentPosts[c.ID]
+		switch {
+		case c.ID <= 0:
+			field("id", "must be positive")
+		case taken:
+			field("id", "is used by another comment")
+		}
+		if !ids[c.PostID] {
+			field("post_id", "isn't a post in the backup")
+		}
+		if c.ParentID != 0 && commentPosts[c.ParentID] != c.PostID {
+			field("parent_id", "isn't an earlier comment on the same post")
+		}
+		if c.Author == "" {
+			field("author", "required")
+		}
+		if c.Body == "" {
+			field("body", "required")
+		}
+		commentPosts[c.ID] = c.PostID
+	}
+	return errs
+}
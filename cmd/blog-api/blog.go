@@ -1,133 +1,1081 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
 )
 
 type Post struct {
-	ID      int    `json:"id"`
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	Author  string `json:"author"`
+	XMLName xml.Name `json:"-" xml:"post"`
+
+	ID int `json:"id" xml:"id"`
+
+	// UUID is handed out by the store, with -uuid-ids it's what URLs use
+	UUID string `json:"uuid" xml:"uuid"`
+
+	Title   string `json:"title" xml:"title"`
+	Content string `json:"content,omitempty" xml:"content,omitempty"`
+	Author  string `json:"author" xml:"author"`
+	Slug    string `json:"slug" xml:"slug"`
+
+	Tags []string `json:"tags" xml:"tags>tag"`
+
+	// Drafts are hidden from readers until published
+	Published bool `json:"published" xml:"published"`
+
+	// Deleting a post moves it to the trash, it's hidden until restored
+	Deleted   bool       `json:"deleted" xml:"deleted"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+
+	// Version goes up on every update, it's also the post's ETag
+	Version int `json:"version" xml:"version"`
+
+	// Likes only changes through POST /posts/{id}/like, reactions through
+	// POST /posts/{id}/reactions and views when the post is fetched on its own.
+	// None of them is an edit, so they leave the version alone.
+	Likes int `json:"likes" xml:"likes"`
+	Views int `json:"views" xml:"views"`
+
+	// Reactions counts each emoji the post got, XML has no maps so it's JSON only
+	Reactions map[string]int `json:"reactions,omitempty" xml:"-"`
+
+	// Attachments are the images uploaded to POST /posts/{id}/attachments
+	Attachments []Attachment `json:"attachments,omitempty" xml:"attachments>attachment,omitempty"`
+
+	// Excerpt stands in for the content in lists
+	Excerpt string `json:"excerpt,omitempty" xml:"excerpt,omitempty"`
+
+	// Derived from the content, see setReadingStats
+	WordCount          int `json:"word_count" xml:"word_count"`
+	ReadingTimeMinutes int `json:"reading_time_minutes" xml:"reading_time_minutes"`
+
+	// Score is how well the post matched a fuzzy search, it's never stored
+	Score float64 `json:"score,omitempty" xml:"score,omitempty"`
+
+	// Only in lists asking for them with ?embed=, never stored either
+	CommentCount *int      `json:"comment_count,omitempty" xml:"comment_count,omitempty"`
+	Comments     []Comment `json:"comments,omitempty" xml:"comments>comment,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// PostPatch holds the fields of a partial update, nil means "leave as is"
+type PostPatch struct {
+	Title   *string `json:"title"`
+	Content *string `json:"content"`
+	Author  *string `json:"author"`
+	Slug    *string `json:"slug"`
+
+	Tags *[]string `json:"tags"`
+
+	Published *bool `json:"published"`
 }
 
-var posts []Post
-var nextID = 1
+// PostList is a single page of posts along with the paging info
+type PostList struct {
+	XMLName xml.Name `json:"-" xml:"posts"`
 
-func init() {
-	initializeSampleData()
+	Data   []Post `json:"data" xml:"post"`
+	Total  int    `json:"total" xml:"total,attr"`
+	Limit  int    `json:"limit" xml:"limit,attr"`
+	Offset int    `json:"offset" xml:"offset,attr"`
+
+	// NextCursor continues after this page with ?cursor=, empty on the last page
+	NextCursor string `json:"next_cursor" xml:"next_cursor,attr,omitempty"`
 }
 
-func initializeSampleData() {
-	posts = []Post{
-		{ID: 1, Title: "Welcome to Go", Content: "Go is awesome for backend development!", Author: "Gopher"},
-		{ID: 2, Title: "Why Choose Go?", Content: "Fast, simple, and reliable.", Author: "Developer"},
-	}
-	nextID = 3
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+
+	// maxBatchIDs caps ?ids= so one request can't ask for the whole store
+	maxBatchIDs = 100
+)
+
+// api holds the dependencies shared by the handlers
+type api struct {
+	cfg       config
+	store     PostStore
+	comments  CommentStore
+	revisions RevisionStore
+	views     *viewCounter
+
+	// sanitize cleans posts before they're stored, nil stores them as sent
+	sanitize sanitizer
+
+	// cache holds encoded post lists, nil when caching is off
+	cache *listCache
+
+	// webhooks hears about every change, nil when none are configured
+	webhooks *webhooks
+
+	// events feeds the /posts/events streams
+	events hub
+
+	// audit records every change and who made it
+	audit *auditLog
+
+	// accessLog gets a line per request, the default logger when nil
+	accessLog *slog.Logger
+
+	// started is when the process started, for the uptime in /health
+	started time.Time
+
+	// shuttingDown turns /ready to 503 once a shutdown has started
+	shuttingDown atomic.Bool
+
+	// seed are the posts from -seed, what /admin/reset goes back to
+	seed []Post
+}
+
+// emit tells whoever is listening that a post changed, and audits who changed it
+func (a *api) emit(r *http.Request, name string, post Post) {
+	a.audit.record(r, name, post.ID)
+	e := event{Event: name, Post: post}
+	a.webhooks.send(r.Context(), e)
+	a.events.publish(e)
 }
 
 func main() {
+	start := time.Now()
+	cfg := loadConfig()
+
+	logger, err := newLogger(cfg.logFormat, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	sanitize, err := newSanitizer(cfg.sanitize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.apiKey == "" {
+		slog.Warn("no API key set, anyone can create, change and delete posts")
+	}
 
+	store, err := openStore(cfg)
+	if err != nil {
+		slog.Error("opening store", "err", err)
+		os.Exit(1)
+	}
+	audit, err := newAuditLog(cfg.auditLog, cfg.apiKey, cfg.trustProxy)
+	if err != nil {
+		slog.Error("opening audit log", "err", err)
+		os.Exit(1)
+	}
+	a := &api{
+		started:   start,
+		cfg:       cfg,
+		store:     store,
+		comments:  NewMemCommentStore(),
+		revisions: NewMemRevisionStore(),
+		views:     newViewCounter(store),
+		sanitize:  sanitize,
+		webhooks:  newWebhooks(cfg.webhookURLs),
+		audit:     audit,
+	}
+	var accessLog *rotatingFile
+	if cfg.accessLog != "" {
+		accessLog, err = newRotatingFile(cfg.accessLog, cfg.accessLogSize, cfg.accessLogBackups)
+		if err != nil {
+			slog.Error("opening access log", "err", err)
+			os.Exit(1)
+		}
+		a.accessLog = slog.New(requestIDHandler{slog.NewJSONHandler(accessLog, nil)})
+	}
+	if cfg.seed != "" {
+		if err := a.seedStore(cfg.seed); err != nil {
+			slog.Error("seeding store", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	server := &http.Server{
+		Addr:              cfg.addr,
+		Handler:           newRouter(a),
+		ReadTimeout:       cfg.readTimeout,
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+	}
+	server.RegisterOnShutdown(a.events.close)
+
+	// Serve in the background so main can wait for a shutdown signal
+	go func() {
+		slog.Info("server starting", "addr", cfg.addr, "max_body", cfg.maxBodySize,
+			"read_timeout", cfg.readTimeout.String(), "read_header_timeout", cfg.readHeaderTimeout.String(),
+			"write_timeout", cfg.writeTimeout.String(), "idle_timeout", cfg.idleTimeout.String())
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server error", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	// BlogService serves the same posts over gRPC on its own port
+	var rpcServer *grpc.Server
+	if cfg.grpcAddr != "" {
+		lis, err := net.Listen("tcp", cfg.grpcAddr)
+		if err != nil {
+			slog.Error("grpc listen error", "err", err)
+			os.Exit(1)
+		}
+		rpcServer = newGRPCServer(a)
+		go func() {
+			slog.Info("grpc server starting", "addr", cfg.grpcAddr)
+			if err := rpcServer.Serve(lis); err != nil {
+				slog.Error("grpc server error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("server shutting down")
+	a.shuttingDown.Store(true)
+
+	// Give in-flight requests and calls some time to finish, both servers at once
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	grpcStopped := make(chan struct{})
+	go func() {
+		stopGRPC(ctx, rpcServer)
+		close(grpcStopped)
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("shutdown error", "err", err)
+	}
+	<-grpcStopped
+	a.views.close()
+	a.webhooks.wait(ctx)
+	if err := a.audit.close(); err != nil {
+		slog.Error("closing audit log", "err", err)
+	}
+	if err := accessLog.Close(); err != nil {
+		slog.Error("closing access log", "err", err)
+	}
+
+	// Flush the store once nothing is writing to it anymore
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("closing store", "err", err)
+		}
+	}
+}
+
+// newRouter wires the middleware and all the routes to the handlers
+func newRouter(a *api) chi.Router {
 	r := chi.NewRouter()
 
-	r.Use(middleware.Logger)
+	// Tag every request with an ID and log it as structured fields
+	r.Use(middleware.RequestID)
+	r.Use(echoRequestID)
+	r.Use(requestLogger(a.accessLog))
 	r.Use(middleware.Recoverer)
 
+	// Count and time every request, served on /metrics
+	var m *metrics
+	if a.cfg.metrics {
+		m = newMetrics(a.store)
+		r.Use(m.middleware)
+	}
+
+	// Answer CORS preflights before anything else runs
+	r.Use(cors(a.cfg.corsOrigins))
+
+	// Tell clients asking with OPTIONS which methods a path has
+	methods := &methodTable{routes: r}
+	r.Use(options(methods))
+
+	// Slow down clients sending too many requests
+	if a.cfg.rateLimit > 0 {
+		r.Use(newRateLimiter(a.cfg.rateLimit, a.cfg.rateBurst, a.cfg.trustProxy).middleware)
+	}
+
+	// Only clients with the API key may write or see drafts
+	if a.cfg.apiKey != "" {
+		r.Use(requireAPIKey(a.cfg.apiKey))
+	}
+
+	// Stop clients from sending us huge bodies
+	r.Use(limitBody(a.cfg.maxBodySize))
+
+	// Gzip responses for clients sending Accept-Encoding: gzip
+	r.Use(middleware.Compress(5))
+
 	// this one is the same as app.use(express.json()) in express
 	r.Use(middleware.SetHeader("Content-Type", "application/json"))
 
-	// Heartbeat endpoint for health checks
+	// Heartbeat endpoint for liveness checks, /ready below is the readiness one
 	r.Use(middleware.Heartbeat("/up"))
 
+	// Give up on requests that run too long, event streams are meant to stay open
+	if a.cfg.requestTimeout > 0 {
+		r.Use(requestTimeout(a.cfg.requestTimeout, "/posts/events"))
+	}
+
+	// Cache post lists until they expire or something is written. It goes inside
+	// the timeout: a write still running after its 503 drops the cache once the
+	// store has changed, not when the 503 goes out.
+	if a.cfg.cacheTTL > 0 {
+		a.cache = newListCache(a.cfg.cacheTTL, a.cfg.cacheSize)
+		r.Use(a.cache.invalidateOnWrite)
+	}
+
+	// Unknown paths and methods get JSON errors like everything else
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed(methods))
+
+	// Readiness probe, 503 until the store answers and once shutdown starts
+	r.Get("/ready", a.getReady)
+
+	// What's running and since when, for people rather than probes
+	r.Get("/health", a.getHealth)
+
+	// OpenAPI spec of every route, and Swagger UI to browse it
+	r.Get("/openapi.json", a.getOpenAPI(r))
+	r.Get("/docs", getDocs)
+
+	// RSS feed of the latest posts
+	r.Get("/feed.xml", a.getFeed)
+
+	// Posts as a spreadsheet, same filters as the list
+	r.Get("/posts.csv", a.exportCSV)
+
+	// GraphQL for clients that want to pick the fields they get
+	if a.cfg.graphql {
+		r.Post("/graphql", a.serveGraphQL(a.graphqlSchema()))
+	}
+
+	// Images attached to posts
+	if a.cfg.uploadDir != "" {
+		r.Get("/uploads/{name}", a.serveUpload)
+	}
+
+	if m != nil {
+		r.Method(http.MethodGet, "/metrics", m.handler())
+	}
+
+	// Who changed what and whole backups, only there when an API key keeps them private
+	if a.cfg.apiKey != "" {
+		r.Group(func(r chi.Router) {
+			r.Use(requireAPIKeyForReads(a.cfg.apiKey))
+			r.Get("/admin/audit", a.getAudit)
+			r.Get("/admin/backup", a.getBackup)
+			r.Post("/admin/restore", a.restoreBackup)
+		})
+	}
+
+	// Comments waiting for moderation. Without a key there'd be no telling
+	// moderators from anyone else, so moderation is only there with one.
+	if a.cfg.apiKey != "" {
+		r.With(requireAPIKeyForReads(a.cfg.apiKey)).Get("/admin/comments/pending", a.getPendingComments)
+	}
+
+	// Without -dev the route doesn't exist, so it's a plain 404
+	if a.cfg.dev {
+		r.Post("/admin/reset", a.resetData)
+	}
+
+	r.Get("/authors", a.getAuthors)                  // Authors and how many posts each has
+	r.Get("/authors/{name}/posts", a.getAuthorPosts) // One author's posts
+
 	// Define route group for posts /posts
 	r.Route("/posts", func(r chi.Router) {
-		r.Get("/", getPosts)          // Get all posts
-		r.Post("/", createPost)       // Create a new post
-		r.Get("/{id}", getPost)       // Get a specific post by ID
-		r.Delete("/{id}", deletePost) // Delete a post by ID
+		r.Get("/", a.getPosts)                 // Get all posts
+		r.Head("/", a.getPosts)                // Just the headers of the list
+		r.Post("/", a.createPost)              // Create a new post
+		r.Post("/batch", a.createPosts)        // Create many posts at once
+		r.Get("/count", a.countPosts)          // Count posts matching the list filters
+		r.Get("/events", a.streamEvents)       // Stream post changes as Server-Sent Events
+		r.Get("/archive", a.getArchive)        // Count posts by month
+		r.Get("/trending", a.getTrending)      // Most viewed posts
+		r.Get("/random", a.getRandomPost)      // One published post picked at random
+		r.Get("/on-this-day", a.getOnThisDay)  // Posts from this day in earlier years
+		r.Get("/slug/{slug}", a.getPostBySlug) // Get a specific post by slug
+		r.Get("/{id}", a.getPost)              // Get a specific post by ID
+		r.Head("/{id}", a.getPost)             // Just the headers of a post
+		r.Get("/{id}/html", a.getPostHTML)     // Get a post's content rendered as HTML
+		r.Get("/{id}/related", a.getRelated)   // Other posts sharing tags with this one
+		r.Put("/{id}", a.updatePost)           // Update a post by ID
+		r.Patch("/{id}", a.patchPost)          // Partially update a post by ID
+		r.Post("/{id}/append", a.appendPost)   // Add to the end of a post's content
+		r.Delete("/{id}", a.deletePost)        // Move a post to the trash
+
+		// List deleted posts. They're drafts as much as published ones, and with
+		// their content, so like the admin reads they need the key.
+		if a.cfg.apiKey != "" {
+			r.With(requireAPIKeyForReads(a.cfg.apiKey)).Get("/trash", a.getTrash)
+		} else {
+			r.Get("/trash", a.getTrash)
+		}
+
+		r.Post("/{id}/restore", a.restorePost)           // Take a post back out of the trash
+		r.Delete("/{id}/permanent", a.deletePermanently) // Delete a post for good
+
+		r.Post("/{id}/publish", a.setPublished(true))    // Make a post visible
+		r.Post("/{id}/unpublish", a.setPublished(false)) // Turn a post back into a draft
+		r.Post("/{id}/like", a.likePost)                 // Like or unlike a post
+		r.Get("/{id}/reactions", a.getReactions)         // Count each emoji a post got
+		r.Post("/{id}/reactions", a.addReaction)         // React to a post with an emoji
+		r.Post("/{id}/clone", a.clonePost)               // Start a new draft from a post
+		if a.cfg.uploadDir != "" {
+			r.Get("/{id}/attachments", a.getAttachments)    // List the images attached to a post
+			r.Post("/{id}/attachments", a.uploadAttachment) // Attach an image to a post
+		}
+
+		// Edit history of a post /posts/{id}/revisions
+		r.Get("/{id}/revisions", a.getRevisions)                   // List the post's earlier revisions
+		r.Post("/{id}/revisions/{rev}/restore", a.restoreRevision) // Roll back to a revision
+		r.Get("/{id}/diff", a.diffRevisions)                       // Compare two revisions
+
+		// Comments on a post /posts/{id}/comments
+		r.Route("/{id}/comments", func(r chi.Router) {
+			r.Get("/", a.getComments)    // Get the post's approved comments
+			r.Post("/", a.createComment) // Comment on the post, pending moderation
+			if a.cfg.apiKey != "" {
+				r.Post("/{cid}/approve", a.approveComment) // Publish a pending comment
+				r.Delete("/{cid}", a.rejectComment)        // Reject or remove a comment and its replies
+			}
+		})
 	})
 
-	fmt.Println("Server starting on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8000", r))
+	return r
 }
 
-func getPosts(w http.ResponseWriter, r *http.Request) {
-	// Encode posts to JSON and send response
-	if err := json.NewEncoder(w).Encode(posts); err != nil {
-		http.Error(w, "Error encoding posts", http.StatusInternalServerError)
+// openStore uses SQLite when a database is configured, the JSON file otherwise
+func openStore(cfg config) (PostStore, error) {
+	if cfg.dbPath != "" {
+		s, err := NewSQLiteStore(cfg.dbPath)
+		if err != nil {
+			return nil, err
+		}
+		s.uniqueTitles = cfg.uniqueTitles
+		return s, nil
+	}
+	s, err := NewFileStore(cfg.dataFile)
+	if err != nil {
+		return nil, err
+	}
+	s.uniqueTitles = cfg.uniqueTitles
+	return s, nil
+}
+
+func (a *api) getPosts(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiate(w, r)
+	if !ok {
 		return
 	}
+	fields, ok := a.parseFields(w, r)
+	if !ok {
+		return
+	}
+	embed, ok := parseEmbed(w, r)
+	if !ok {
+		return
+	}
+	// Asking for something to be embedded asks for its field too
+	if fields != nil {
+		for name := range embed {
+			fields[name] = true
+		}
+	}
+
+	// A cached copy skips reading the store and encoding altogether.
+	// Encode sorts the params, so their order doesn't matter. The path is part
+	// of the key since other routes list posts too, with their own Link URLs.
+	key := format + " " + r.URL.Path + "?" + r.URL.Query().Encode()
+	var gen uint64
+	if a.cache != nil {
+		if entry, ok := a.cache.get(key); ok {
+			w.Header().Set("X-Cache", "HIT")
+			if entry.link != "" {
+				w.Header().Set("Link", entry.link)
+			}
+			if !fresh(w, r, entry.etag, entry.modified) {
+				writeEncoded(w, format, entry.body)
+			}
+			return
+		}
+		w.Header().Set("X-Cache", "MISS")
+		gen = a.cache.generation()
+	}
+
+	// Either the posts asked for by ID or a filtered and sorted page
+	var list PostList
+	if r.URL.Query().Has("ids") {
+		if list, ok = a.postsByIDs(w, r); !ok {
+			return
+		}
+	} else {
+		if list, ok = a.postsPage(w, r); !ok {
+			return
+		}
+		// Link lets generic clients page through without knowing our params
+		w.Header().Set("Link", pageLinks(r, list.Offset, list.Limit, list.Total, list.NextCursor))
+	}
+
+	// Lists only carry an excerpt, the full content comes with a single post
+	withExcerpts(list.Data)
+	highlightPosts(list.Data, highlightTerm(r))
+	a.embedComments(list.Data, embed)
+
+	// Encode posts as JSON or XML, the ETag is a hash of the encoded body
+	body, err := marshalFields(format, list, fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error encoding posts")
+		return
+	}
+	body = indentJSON(r, format, body)
+
+	etag, modified := bodyETag(body), lastModified(list.Data)
+	if a.cache != nil {
+		link := w.Header().Get("Link")
+		a.cache.put(cacheEntry{key: key, body: body, etag: etag, link: link, modified: modified, gen: gen})
+	}
+
+	// Last-Modified misses deletions, the ETag catches them
+	if fresh(w, r, etag, modified) {
+		return
+	}
+	writeEncoded(w, format, body)
 }
 
-func createPost(w http.ResponseWriter, r *http.Request) {
+// postsPage filters, sorts and pages the posts as the query string says,
+// writing a 400 for bad params
+func (a *api) postsPage(w http.ResponseWriter, r *http.Request) (PostList, bool) {
+	// Read paging params, bad values fall back to the defaults
+	limit := queryInt(r, "limit", defaultLimit)
+	if limit == 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := queryInt(r, "offset", 0)
+
+	// Keep only the posts matching the search term and tags, if any
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return PostList{}, false
+	}
+	matched := []Post{}
+	for _, post := range a.store.List(r.Context()) {
+		if filter.match(post) {
+			matched = append(matched, post)
+		}
+	}
+
+	// matched is our own copy, so sorting it leaves the store alone.
+	// Fuzzy results come best match first unless another order was asked for.
+	sortKey := r.URL.Query().Get("sort")
+	if filter.fuzzy && filter.q != "" {
+		for i := range matched {
+			matched[i].Score = fuzzyScore(matched[i], filter.q)
+		}
+		if sortKey == "" {
+			sortKey = "-score"
+		}
+	}
+	if err := sortPosts(matched, sortKey); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return PostList{}, false
+	}
+
+	// Slice out the requested page, past the end is just an empty page.
+	// A cursor starts right after the last ID the client saw, so posts added
+	// or deleted in between can't shift the pages the way they shift an offset.
+	start := min(offset, len(matched))
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, "Invalid cursor")
+			return PostList{}, false
+		}
+		if !idOrder(sortKey) {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, "A cursor only works with the default ID order")
+			return PostList{}, false
+		}
+		start = sort.Search(len(matched), func(i int) bool { return matched[i].ID > after })
+		offset = start
+	}
+	end := min(start+limit, len(matched))
+	page := matched[start:end]
+
+	// Hand out a cursor while there's a next page
+	var next string
+	if idOrder(sortKey) && end < len(matched) && len(page) > 0 {
+		next = encodeCursor(page[len(page)-1].ID)
+	}
+
+	return PostList{
+		Data:   page,
+		Total:  len(matched),
+		Limit:  limit,
+		Offset: offset,
+
+		NextCursor: next,
+	}, true
+}
+
+// postsByIDs returns the posts listed in ?ids=1,3,5 in that order, skipping IDs
+// that don't exist or are repeated
+func (a *api) postsByIDs(w http.ResponseWriter, r *http.Request) (PostList, bool) {
+	fields := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(fields) > maxBatchIDs {
+		writeError(w, http.StatusBadRequest, codeInvalidQuery, fmt.Sprintf("At most %d IDs can be fetched at once", maxBatchIDs))
+		return PostList{}, false
+	}
+
+	posts := []Post{}
+	seen := make(map[int]bool)
+	for _, field := range fields {
+		id, err := a.postID(r.Context(), strings.TrimSpace(field))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, fmt.Sprintf("Invalid post ID %q in ids", field))
+			return PostList{}, false
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if post, ok := a.store.Get(r.Context(), id); ok && visible(post, r) {
+			posts = append(posts, post)
+		}
+	}
+	return PostList{Data: posts, Total: len(posts), Limit: len(fields)}, true
+}
+
+func (a *api) countPosts(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]int{"count": a.store.Count(r.Context(), filter.match)})
+}
+
+func (a *api) createPost(w http.ResponseWriter, r *http.Request) {
 	var newPost Post
 
 	// Decode JSON from request body
-	if err := json.NewDecoder(r.Body).Decode(&newPost); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if !decodeJSON(w, r, &newPost) {
+		return
+	}
+
+	// Validate the fields, reporting every problem at once
+	if errs := a.prepareNewPost(&newPost); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	// A non-zero ID is kept as is, which lets imports preserve their IDs
+	newPost, err := a.store.Create(r.Context(), newPost)
+	if err != nil {
+		writeCreateError(w, r, err)
+		return
+	}
+
+	a.emit(r, eventCreated, newPost)
+
+	// Return created post, Location is where clients find it from now on
+	setETag(w, newPost)
+	w.Header().Set("Location", baseURL(r)+a.postPath(newPost))
+	writeJSON(w, r, http.StatusCreated, newPost)
+}
+
+// prepareNewPost validates a post about to be created and cleans up its tags and slug,
+// returning the problems when there are any
+func (a *api) prepareNewPost(p *Post) []FieldError {
+	errs := a.checkFields(&p.Title, &p.Content, &p.Author, "required")
+	if p.ID < 0 {
+		errs = append(errs, FieldError{Field: "id", Message: "can't be negative"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	p.Tags = normalizeTags(p.Tags)
+
+	// Only deleting a post puts it in the trash, and only the store hands out UUIDs
+	p.Deleted, p.DeletedAt = false, nil
+	p.UUID = ""
+	p.CommentCount, p.Comments = nil, nil
+
+	// An empty slug is generated from the title by the store
+	if p.Slug != "" {
+		p.Slug = slugify(p.Slug)
+	}
+	return nil
+}
+
+// createPosts creates a whole array of posts at once, or none of them if any is invalid
+func (a *api) createPosts(w http.ResponseWriter, r *http.Request) {
+	var posts []Post
+	if !decodeJSON(w, r, &posts) {
+		return
+	}
+	if len(posts) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidFields, "At least one post is required")
 		return
 	}
 
-	// Validate required fields
-	if newPost.Title == "" || newPost.Content == "" || newPost.Author == "" {
-		http.Error(w, "Title, content, and author are required", http.StatusBadRequest)
+	// Validate everything before touching the store, fields are named after
+	// their post's index like "1.title"
+	var errs []FieldError
+	for i := range posts {
+		for _, e := range a.prepareNewPost(&posts[i]) {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("%d.%s", i, e.Field), Message: e.Message})
+		}
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, errs)
 		return
 	}
 
-	newPost.ID = nextID
-	nextID++
-	posts = append(posts, newPost)
+	posts, err := a.store.CreateMany(r.Context(), posts)
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) && errors.Is(err, ErrPostExists) {
+		writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("Post %d: a post with this ID already exists", batchErr.Index))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "creating posts", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error creating posts")
+		return
+	}
+	for _, post := range posts {
+		a.emit(r, eventCreated, post)
+	}
 
-	// Return created post
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newPost)
+	writeJSON(w, r, http.StatusCreated, posts)
 }
 
-func getPost(w http.ResponseWriter, r *http.Request) {
+func (a *api) getPost(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+	fields, ok := a.parseFields(w, r)
+	if !ok {
+		return
+	}
+
 	// Get ID from URL parameter
 	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
+	id, err := a.postID(r.Context(), idStr)
 	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
 		return
 	}
 
-	for _, post := range posts {
-		if post.ID == id {
-			json.NewEncoder(w).Encode(post)
+	// Drafts look like they don't exist unless asked for
+	post, ok := a.store.Get(r.Context(), id)
+	if !ok || !visible(post, r) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	// Checking on a post isn't reading it
+	if r.Method != http.MethodHead {
+		a.views.count(id)
+	}
+
+	body, err := marshalFields(format, post, fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error encoding post")
+		return
+	}
+	body = indentJSON(r, format, body)
+	if fresh(w, r, postETag(post.Version, body), post.UpdatedAt) {
+		return
+	}
+	writeEncoded(w, format, body)
+}
+
+func (a *api) getPostBySlug(w http.ResponseWriter, r *http.Request) {
+	post, ok := a.store.GetBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if !ok || !visible(post, r) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	a.views.count(post.ID)
+
+	body, err := marshal(formatJSON, post)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error encoding post")
+		return
+	}
+	body = indentJSON(r, formatJSON, body)
+	if fresh(w, r, postETag(post.Version, body), post.UpdatedAt) {
+		return
+	}
+	writeEncoded(w, formatJSON, body)
+}
+
+func (a *api) updatePost(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	var updated Post
+
+	// Decode JSON from request body
+	if !decodeJSON(w, r, &updated) {
+		return
+	}
+
+	// Validate the fields, reporting every problem at once
+	if errs := a.checkFields(&updated.Title, &updated.Content, &updated.Author, "required"); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+	updated.Tags = normalizeTags(updated.Tags)
+
+	current, ok := a.findPost(r.Context(), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	if !checkIfMatch(w, r, current, &updated) {
+		return
+	}
+	updated.Deleted, updated.DeletedAt = false, nil
+	updated.CommentCount, updated.Comments = nil, nil
+
+	// Without an explicit slug, keep the old one unless the title changed
+	switch {
+	case updated.Slug != "":
+		updated.Slug = slugify(updated.Slug)
+	case updated.Title == current.Title:
+		updated.Slug = current.Slug
+	}
+
+	// Replace the post, the store keeps the original ID
+	updated, err = a.store.Update(r.Context(), id, updated)
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	a.saveRevision(current, updated)
+	a.emit(r, eventUpdated, updated)
+
+	setETag(w, updated)
+	writeJSON(w, r, http.StatusOK, updated)
+}
+
+func (a *api) patchPost(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	var patch PostPatch
+
+	// Decode JSON from request body
+	if !decodeJSON(w, r, &patch) {
+		return
+	}
+
+	// Provided fields can't be empty
+	if errs := a.checkFields(patch.Title, patch.Content, patch.Author, "can't be empty"); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	current, ok := a.findPost(r.Context(), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	post := current
+	if !checkIfMatch(w, r, current, &post) {
+		return
+	}
+
+	// Apply only the provided fields
+	if patch.Title != nil && *patch.Title != post.Title {
+		post.Title = *patch.Title
+		post.Slug = "" // has the store regenerate it, unless one is sent too
+	}
+	if patch.Slug != nil {
+		post.Slug = slugify(*patch.Slug)
+	}
+	if patch.Content != nil {
+		post.Content = *patch.Content
+	}
+	if patch.Author != nil {
+		post.Author = *patch.Author
+	}
+	if patch.Tags != nil {
+		post.Tags = normalizeTags(*patch.Tags)
+	}
+	if patch.Published != nil {
+		post.Published = *patch.Published
+	}
+
+	// The version from our read guards against a concurrent change in between
+	post, err = a.store.Update(r.Context(), id, post)
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	a.saveRevision(current, post)
+	a.emit(r, eventUpdated, post)
+
+	setETag(w, post)
+	writeJSON(w, r, http.StatusOK, post)
+}
+
+// setPublished returns the handler behind /publish and /unpublish. Asking for the
+// state the post is already in changes nothing and still answers 200.
+func (a *api) setPublished(published bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get ID from URL parameter
+		idStr := chi.URLParam(r, "id")
+		id, err := a.postID(r.Context(), idStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+			return
+		}
+
+		post, ok := a.findPost(r.Context(), id)
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+			return
+		}
+
+		if post.Published != published {
+			post.Published = published
+			post, err = a.store.Update(r.Context(), id, post)
+			if err != nil {
+				writeUpdateError(w, r, err)
+				return
+			}
+			a.emit(r, eventUpdated, post)
+		}
+
+		setETag(w, post)
+		writeJSON(w, r, http.StatusOK, post)
+	}
+}
+
+// likePost adds a like and answers with the new count, ?delta=-1 takes one back
+func (a *api) likePost(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	delta := 1
+	if d := r.URL.Query().Get("delta"); d != "" {
+		if delta, err = strconv.Atoi(d); err != nil || (delta != 1 && delta != -1) {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, "The delta must be 1 or -1")
 			return
 		}
 	}
 
-	// Post not found
-	http.Error(w, "Post not found", http.StatusNotFound)
+	if _, ok := a.findPost(r.Context(), id); !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	likes, err := a.store.AddLikes(r.Context(), id, delta)
+	if errors.Is(err, ErrPostNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "liking post", "id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error liking post")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]int{"likes": likes})
 }
 
-func deletePost(w http.ResponseWriter, r *http.Request) {
+func (a *api) deletePost(w http.ResponseWriter, r *http.Request) {
 	// Get ID from URL parameter
 	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
+	id, err := a.postID(r.Context(), idStr)
 	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	post, ok := a.findPost(r.Context(), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
 		return
 	}
 
-	// Find and remove post
-	for i, post := range posts {
-		if post.ID == id {
-			posts = append(posts[:i], posts[i+1:]...)
-			w.WriteHeader(http.StatusNoContent)
+	// With -unpublish-on-delete a live post only goes back to being a draft,
+	// deleting it again sends it to the trash
+	if a.cfg.unpublishOnDelete && post.Published {
+		post.Published = false
+		post, err = a.store.Update(r.Context(), id, post)
+		if err != nil {
+			writeUpdateError(w, r, err)
 			return
 		}
+		a.emit(r, eventUpdated, post)
+
+		setETag(w, post)
+		writeJSON(w, r, http.StatusOK, post)
+		return
+	}
+
+	// Only move it to the trash, DELETE /posts/{id}/permanent really removes it
+	now := time.Now().UTC()
+	post.Deleted, post.DeletedAt = true, &now
+	post, err = a.store.Update(r.Context(), id, post)
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
 	}
+	a.emit(r, eventDeleted, post)
 
-	// Post not found
-	http.Error(w, "Post not found", http.StatusNotFound)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// queryInt reads a non-negative int query param, returning def when it's missing or invalid
+func queryInt(r *http.Request, key string, def int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
 }
@@ -0,0 +1,3847 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/edaywalid/golang-discovery-workshop/cmd/blog-api/blogpb"
+	"golang.org/x/net/html"
+)
+
+// setup returns a router over a fresh store holding only the sample data
+func setup(t *testing.T) http.Handler {
+	t.Helper()
+
+	return setupWith(t, config{})
+}
+
+// setupWith is setup with a custom config
+func setupWith(t *testing.T, cfg config) http.Handler {
+	t.Helper()
+
+	return newRouter(newTestAPI(cfg))
+}
+
+// newTestAPI wires in-memory stores seeded with the sample posts
+func newTestAPI(cfg config) *api {
+	store := NewMemStore()
+	initializeSampleData(store)
+	audit, _ := newAuditLog("", cfg.apiKey, cfg.trustProxy)
+	a := &api{cfg: cfg, store: store, comments: NewMemCommentStore(), revisions: NewMemRevisionStore(), audit: audit, started: time.Now()}
+	if cfg.sanitize != "" {
+		a.sanitize, _ = newSanitizer(cfg.sanitize)
+	}
+	return a
+}
+
+// defaultConfig is the config the server starts with when it's given no flags
+func defaultConfig(t *testing.T) config {
+	t.Helper()
+
+	cfg, err := parseConfig(flag.NewFlagSet("blog-api", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// do sends a request through the router and returns the recorded response
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals the response body into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d (body %q)", rec.Code, want, rec.Body.String())
+	}
+}
+
+func TestGetPosts(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/posts", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var list PostList
+	decode(t, rec, &list)
+	if list.Total != 2 || len(list.Data) != 2 {
+		t.Fatalf("got %d posts (total %d), want 2", len(list.Data), list.Total)
+	}
+	if list.Data[0].ID != 1 || list.Data[1].ID != 2 {
+		t.Errorf("got IDs %d, %d, want 1, 2", list.Data[0].ID, list.Data[1].ID)
+	}
+}
+
+func TestGetPostsByAuthor(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/posts?author=gopher", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var list PostList
+	decode(t, rec, &list)
+	if len(list.Data) != 1 || list.Data[0].Author != "Gopher" {
+		t.Errorf("got %+v, want only the post by Gopher", list.Data)
+	}
+}
+
+func TestCountPosts(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/posts/count?tag=intro", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var got struct{ Count int }
+	decode(t, rec, &got)
+	if got.Count != 1 {
+		t.Errorf("count = %d, want 1", got.Count)
+	}
+}
+
+func TestGetPost(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/posts/1", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var post Post
+	decode(t, rec, &post)
+	if post.ID != 1 || post.Title != "Welcome to Go" {
+		t.Errorf("got post %d %q, want 1 %q", post.ID, post.Title, "Welcome to Go")
+	}
+}
+
+func TestGetPostNotFound(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/posts/99", "")
+	expectStatus(t, rec, http.StatusNotFound)
+
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if apiErr.Code != codeNotFound || apiErr.Status != http.StatusNotFound {
+		t.Errorf("got error %+v, want code %q", apiErr, codeNotFound)
+	}
+}
+
+func TestCreatePost(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodPost, "/posts", `{"title":"New","content":"Hello","author":"Me","published":true}`)
+	expectStatus(t, rec, http.StatusCreated)
+
+	var post Post
+	decode(t, rec, &post)
+	if post.ID != 3 || post.Title != "New" || post.Content != "Hello" || post.Author != "Me" {
+		t.Errorf("got %+v, want post 3 with the sent fields", post)
+	}
+	if post.CreatedAt.IsZero() {
+		t.Error("created_at was not set")
+	}
+	if got := rec.Header().Get("Location"); got != "http://example.com/posts/3" {
+		t.Errorf("Location = %q, want http://example.com/posts/3", got)
+	}
+
+	// The new post can be fetched back
+	rec = do(t, h, http.MethodGet, "/posts/3", "")
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestDrafts(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodPost, "/posts", `{"title":"Draft","content":"Not yet","author":"Me"}`)
+	expectStatus(t, rec, http.StatusCreated)
+
+	// Hidden by default
+	rec = do(t, h, http.MethodGet, "/posts/3", "")
+	expectStatus(t, rec, http.StatusNotFound)
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts", ""), &list)
+	if list.Total != 2 {
+		t.Errorf("total = %d, want the 2 published posts", list.Total)
+	}
+
+	// Shown when asked for
+	rec = do(t, h, http.MethodGet, "/posts/3?include_drafts=true", "")
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, do(t, h, http.MethodGet, "/posts?include_drafts=true", ""), &list)
+	if list.Total != 3 {
+		t.Errorf("total = %d, want 3 with the draft", list.Total)
+	}
+}
+
+func TestPostStatus(t *testing.T) {
+	h := setupWith(t, config{apiKey: "secret"})
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	expectStatus(t, send(http.MethodPost, "/posts", `{"title":"Draft","content":"Not yet","author":"Me"}`), http.StatusCreated)
+
+	for target, want := range map[string][]int{
+		"/posts":                          {1, 2},
+		"/posts?status=published":         {1, 2},
+		"/posts?status=draft":             {3},
+		"/posts?status=all":               {1, 2, 3},
+		"/posts?status=all&sort=-id":      {3, 2, 1},
+		"/posts?status=all&limit=1":       {1},
+		"/posts?status=draft&author=Me":   {3},
+		"/posts?status=draft&author=John": {},
+	} {
+		rec := send(http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var list PostList
+		decode(t, rec, &list)
+		got := []int{}
+		for _, post := range list.Data {
+			got = append(got, post.ID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got posts %v, want %v", target, got, want)
+		}
+	}
+
+	// Drafts need the key, published posts don't
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?status=published", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?status=draft", ""), http.StatusUnauthorized)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?status=all", ""), http.StatusUnauthorized)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?include_drafts=true", ""), http.StatusUnauthorized)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/3?status=all", ""), http.StatusUnauthorized)
+	expectStatus(t, send(http.MethodGet, "/posts/3?status=all", ""), http.StatusOK)
+
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?status=hidden", ""), http.StatusBadRequest)
+}
+
+func TestCreatePostWithID(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodPost, "/posts", `{"id":10,"title":"Imported","content":"Hello","author":"Me"}`)
+	expectStatus(t, rec, http.StatusCreated)
+
+	// Taking an existing ID is a conflict
+	rec = do(t, h, http.MethodPost, "/posts", `{"id":10,"title":"Again","content":"Hello","author":"Me"}`)
+	expectStatus(t, rec, http.StatusConflict)
+
+	// Auto IDs carry on after the imported one
+	rec = do(t, h, http.MethodPost, "/posts", `{"title":"Next","content":"Hello","author":"Me"}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var post Post
+	decode(t, rec, &post)
+	if post.ID != 11 {
+		t.Errorf("ID = %d, want 11", post.ID)
+	}
+}
+
+func TestUniqueTitles(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	sqlite.uniqueTitles = true
+	mem := NewMemStore()
+	initializeSampleData(mem)
+	mem.uniqueTitles = true
+
+	for name, store := range map[string]PostStore{"mem": mem, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			a := newTestAPI(config{})
+			a.store = store
+			h := newRouter(a)
+
+			rec := do(t, h, http.MethodPost, "/posts", `{"title":"  welcome TO go ","content":"Again","author":"Me"}`)
+			expectStatus(t, rec, http.StatusConflict)
+			var apiErr APIError
+			decode(t, rec, &apiErr)
+			if apiErr.Code != codeTitleTaken || apiErr.ExistingID != 1 {
+				t.Errorf("got %+v, want code %s and existing_id 1", apiErr, codeTitleTaken)
+			}
+
+			// A trashed post gives its title up
+			expectStatus(t, do(t, h, http.MethodDelete, "/posts/1", ""), http.StatusNoContent)
+			expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"Welcome to Go","content":"Again","author":"Me"}`), http.StatusCreated)
+		})
+	}
+
+	// Off by default
+	h := setup(t)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"Welcome to Go","content":"Again","author":"Me"}`), http.StatusCreated)
+}
+
+func TestUUIDIDs(t *testing.T) {
+	h := setupWith(t, config{uuidIDs: true})
+
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts", ""), &list)
+	first, second := list.Data[0], list.Data[1]
+	if first.UUID == "" || first.UUID == second.UUID {
+		t.Fatalf("got UUIDs %q and %q", first.UUID, second.UUID)
+	}
+
+	// Numeric IDs aren't accepted anymore, UUIDs are
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1", ""), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/"+strings.ToUpper(first.UUID), ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/00000000-0000-4000-8000-000000000000", ""), http.StatusNotFound)
+
+	var post Post
+	rec := do(t, h, http.MethodPatch, "/posts/"+first.UUID, `{"title":"Renamed"}`)
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &post)
+	if post.ID != 1 || post.UUID != first.UUID {
+		t.Errorf("got post %d %q, want 1 %q", post.ID, post.UUID, first.UUID)
+	}
+
+	decode(t, do(t, h, http.MethodGet, "/posts?ids="+second.UUID+","+first.UUID, ""), &list)
+	if len(list.Data) != 2 || list.Data[0].ID != 2 {
+		t.Errorf("got %+v, want posts 2 and 1", list.Data)
+	}
+
+	// Clients can't pick the UUID, the store hands one out
+	rec = do(t, h, http.MethodPost, "/posts", `{"title":"New","content":"Hi","author":"Me","uuid":"`+first.UUID+`"}`)
+	expectStatus(t, rec, http.StatusCreated)
+	decode(t, rec, &post)
+	if post.UUID == "" || post.UUID == first.UUID {
+		t.Errorf("created post has UUID %q", post.UUID)
+	}
+
+	// Both other stores hand them out and find posts by them
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	created, err := sqlite.Create(context.Background(), Post{Title: "New", Content: "Hi", Author: "Me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := sqlite.GetByUUID(context.Background(), created.UUID); !ok || got.ID != created.ID {
+		t.Errorf("GetByUUID(%q) = %+v, %v", created.UUID, got, ok)
+	}
+
+	// Files from before UUIDs get them once, and keep them
+	path := filepath.Join(t.TempDir(), "posts.json")
+	if err := os.WriteFile(path, []byte(`[{"id":1,"title":"Old","content":"Hi","author":"Me"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var uuids []string
+	for range 2 {
+		store, err := NewFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old, _ := store.Get(context.Background(), 1)
+		uuids = append(uuids, old.UUID)
+	}
+	if uuids[0] == "" || uuids[0] != uuids[1] {
+		t.Errorf("got UUIDs %q across restarts", uuids)
+	}
+}
+
+func TestSlugs(t *testing.T) {
+	h := setup(t)
+
+	var first, second Post
+	rec := do(t, h, http.MethodPost, "/posts", `{"title":"Hello, World!","content":"Hi","author":"Me","published":true}`)
+	decode(t, rec, &first)
+	rec = do(t, h, http.MethodPost, "/posts", `{"title":"Hello World","content":"Hi","author":"Me","published":true}`)
+	decode(t, rec, &second)
+	if first.Slug != "hello-world" || second.Slug != "hello-world-2" {
+		t.Fatalf("slugs = %q, %q, want hello-world, hello-world-2", first.Slug, second.Slug)
+	}
+
+	rec = do(t, h, http.MethodGet, "/posts/slug/hello-world-2", "")
+	expectStatus(t, rec, http.StatusOK)
+	var post Post
+	decode(t, rec, &post)
+	if post.ID != second.ID {
+		t.Errorf("got post %d, want %d", post.ID, second.ID)
+	}
+}
+
+func TestCreatePosts(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodPost, "/posts/batch", `[
+		{"title":"One","content":"a","author":"x"},
+		{"id":10,"title":"Two","content":"b","author":"y"}
+	]`)
+	expectStatus(t, rec, http.StatusCreated)
+	var posts []Post
+	decode(t, rec, &posts)
+	if len(posts) != 2 || posts[0].ID != 3 || posts[1].ID != 10 {
+		t.Fatalf("got %+v, want posts 3 and 10", posts)
+	}
+
+	// One bad post or a taken ID means nothing gets created
+	rec = do(t, h, http.MethodPost, "/posts/batch", `[{"title":"Ok","content":"a","author":"x"},{"title":"Bad"}]`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	want := []FieldError{{"1.content", "required"}, {"1.author", "required"}}
+	if !reflect.DeepEqual(apiErr.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", apiErr.Errors, want)
+	}
+
+	rec = do(t, h, http.MethodPost, "/posts/batch", `[{"title":"Ok","content":"a","author":"x"},{"id":10,"title":"Dup","content":"a","author":"x"}]`)
+	expectStatus(t, rec, http.StatusConflict)
+
+	rec = do(t, h, http.MethodGet, "/posts/count?include_drafts=true", "")
+	var count map[string]int
+	decode(t, rec, &count)
+	if count["count"] != 4 {
+		t.Errorf("count = %d, want 4", count["count"])
+	}
+}
+
+func TestCreatePostInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"missing fields", `{"title":"Only a title"}`, http.StatusUnprocessableEntity, codeInvalidFields},
+		{"bad json", `{"title":`, http.StatusBadRequest, codeInvalidJSON},
+		{"unknown field", `{"titel":"Typo","content":"Hello","author":"Me"}`, http.StatusBadRequest, codeInvalidJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setup(t)
+
+			rec := do(t, h, http.MethodPost, "/posts", tt.body)
+			expectStatus(t, rec, tt.status)
+
+			var apiErr APIError
+			decode(t, rec, &apiErr)
+			if apiErr.Code != tt.code {
+				t.Errorf("code = %q, want %q", apiErr.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestCreatePostTooLarge(t *testing.T) {
+	h := setupWith(t, config{maxBodySize: 64})
+
+	body := `{"title":"Big","content":"` + strings.Repeat("x", 100) + `","author":"Me"}`
+	rec := do(t, h, http.MethodPost, "/posts", body)
+	expectStatus(t, rec, http.StatusRequestEntityTooLarge)
+}
+
+func TestIfMatch(t *testing.T) {
+	h := setup(t)
+
+	// The ETag a GET hands out starts with the version, so it works in If-Match
+	rec := do(t, h, http.MethodGet, "/posts/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"1-`) {
+		t.Fatalf("ETag = %s, want version 1's", etag)
+	}
+
+	patch := func(ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/posts/1", strings.NewReader(`{"title":"Hello"}`))
+		req.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = patch(etag)
+	expectStatus(t, rec, http.StatusOK)
+	var post Post
+	decode(t, rec, &post)
+	if post.Version != 2 || rec.Header().Get("ETag") != `"2"` {
+		t.Errorf("version after update = %d (ETag %s), want 2", post.Version, rec.Header().Get("ETag"))
+	}
+
+	// Someone else already moved the post past version 1
+	rec = patch(`"1"`)
+	expectStatus(t, rec, http.StatusPreconditionFailed)
+
+	rec = patch("nope")
+	expectStatus(t, rec, http.StatusBadRequest)
+}
+
+func TestDeletePost(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodDelete, "/posts/1", "")
+	expectStatus(t, rec, http.StatusNoContent)
+
+	rec = do(t, h, http.MethodGet, "/posts/1", "")
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestUnpublishOnDelete(t *testing.T) {
+	h := setupWith(t, config{unpublishOnDelete: true})
+
+	// A published post only goes back to being a draft
+	rec := do(t, h, http.MethodDelete, "/posts/1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var post Post
+	decode(t, rec, &post)
+	if post.ID != 1 || post.Published || post.Deleted {
+		t.Errorf("got %+v, want post 1 unpublished and not deleted", post)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1?include_drafts=true", ""), http.StatusOK)
+
+	// Deleting the draft sends it to the trash as usual
+	expectStatus(t, do(t, h, http.MethodDelete, "/posts/1", ""), http.StatusNoContent)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1?include_drafts=true", ""), http.StatusNotFound)
+	var trash []Post
+	decode(t, do(t, h, http.MethodGet, "/posts/trash", ""), &trash)
+	if len(trash) != 1 || trash[0].ID != 1 {
+		t.Errorf("trash = %+v, want post 1", trash)
+	}
+}
+
+func TestDeletePostNotFound(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodDelete, "/posts/99", "")
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestTrash(t *testing.T) {
+	a := newTestAPI(config{})
+	h := newRouter(a)
+	expectStatus(t, do(t, h, http.MethodDelete, "/posts/1", ""), http.StatusNoContent)
+
+	// Trashed posts are hidden everywhere and can't be deleted twice
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts", ""), &list)
+	if list.Total != 1 || list.Data[0].ID != 2 {
+		t.Errorf("got %+v, want only post 2 listed", list.Data)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/slug/welcome-to-go", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodPatch, "/posts/1", `{"title":"Back?"}`), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodDelete, "/posts/1", ""), http.StatusNotFound)
+
+	rec := do(t, h, http.MethodGet, "/posts/trash", "")
+	expectStatus(t, rec, http.StatusOK)
+	var trash []Post
+	decode(t, rec, &trash)
+	if len(trash) != 1 || trash[0].ID != 1 || !trash[0].Deleted || trash[0].DeletedAt == nil {
+		t.Fatalf("got trash %+v, want post 1 deleted", trash)
+	}
+
+	rec = do(t, h, http.MethodPost, "/posts/1/restore", "")
+	expectStatus(t, rec, http.StatusOK)
+	var post Post
+	decode(t, rec, &post)
+	if post.Deleted || post.DeletedAt != nil || post.Title != "Welcome to Go" {
+		t.Errorf("restored post = %+v", post)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/restore", ""), http.StatusNotFound)
+
+	// Permanent deletes work from the trash or straight away
+	do(t, h, http.MethodDelete, "/posts/1", "")
+	approvedComment(t, a, h, 2, `{"author":"Reader","body":"Nice"}`)
+	do(t, h, http.MethodPost, "/posts/2/comments", `{"author":"Reader","body":"Waiting"}`)
+	expectStatus(t, do(t, h, http.MethodDelete, "/posts/1/permanent", ""), http.StatusNoContent)
+	expectStatus(t, do(t, h, http.MethodDelete, "/posts/2/permanent", ""), http.StatusNoContent)
+	decode(t, do(t, h, http.MethodGet, "/posts/trash", ""), &trash)
+	if len(trash) != 0 {
+		t.Errorf("got trash %+v, want it empty", trash)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/restore", ""), http.StatusNotFound)
+
+	// Its comments went with it, a new post with the same ID has none
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"id":2,"title":"New","content":"Fresh","author":"Me","published":true}`), http.StatusCreated)
+	var comments []Comment
+	decode(t, do(t, h, http.MethodGet, "/posts/2/comments", ""), &comments)
+	pending := a.comments.Pending()
+	if len(comments) != 0 || len(pending) != 0 {
+		t.Errorf("got comments %+v and pending %+v, want none left", comments, pending)
+	}
+}
+
+// approvedComment comments on a post and approves the comment, as a moderator would
+func TestTrashNeedsKey(t *testing.T) {
+	h := setupWith(t, config{apiKey: "secret"})
+	req := httptest.NewRequest(http.MethodDelete, "/posts/1", nil)
+	req.Header.Set("X-API-Key", "secret")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// What's in the trash isn't public, though reads usually are
+	rec := do(t, h, http.MethodGet, "/posts/trash", "")
+	expectStatus(t, rec, http.StatusUnauthorized)
+	if strings.Contains(rec.Body.String(), "Welcome") {
+		t.Errorf("the trash leaked: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/posts/trash", nil)
+	req.Header.Set("X-API-Key", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusOK)
+	var trash []Post
+	decode(t, rec, &trash)
+	if len(trash) != 1 || trash[0].ID != 1 {
+		t.Errorf("got trash %+v, want post 1", trash)
+	}
+}
+
+func approvedComment(t *testing.T, a *api, h http.Handler, postID int, body string) Comment {
+	t.Helper()
+
+	rec := do(t, h, http.MethodPost, fmt.Sprintf("/posts/%d/comments", postID), body)
+	expectStatus(t, rec, http.StatusCreated)
+	var c Comment
+	decode(t, rec, &c)
+	// Moderating needs the API key, which these tests mostly go without
+	if _, ok := a.comments.Approve(postID, c.ID); !ok {
+		t.Fatalf("comment %d on post %d wasn't there to approve", c.ID, postID)
+	}
+	return c
+}
+
+func TestComments(t *testing.T) {
+	a := newTestAPI(config{})
+	h := newRouter(a)
+
+	// A post without comments lists an empty array
+	rec := do(t, h, http.MethodGet, "/posts/1/comments", "")
+	expectStatus(t, rec, http.StatusOK)
+	if got := strings.TrimSpace(rec.Body.String()); got != "[]" {
+		t.Fatalf("body = %s, want []", got)
+	}
+
+	approvedComment(t, a, h, 1, `{"author":"Reader","body":"Nice post"}`)
+
+	rec = do(t, h, http.MethodGet, "/posts/1/comments", "")
+	var comments []Comment
+	decode(t, rec, &comments)
+	if len(comments) != 1 || comments[0].PostID != 1 || comments[0].Body != "Nice post" {
+		t.Errorf("got %+v, want the one comment on post 1", comments)
+	}
+}
+
+func TestCommentModeration(t *testing.T) {
+	h := setupWith(t, config{apiKey: "secret"})
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Readers comment without the key, but can't approve their own comments
+	rec := do(t, h, http.MethodPost, "/posts/1/comments", `{"author":"Reader","body":"First","approved":true}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var first Comment
+	decode(t, rec, &first)
+	if first.Approved {
+		t.Error("a new comment came out approved")
+	}
+	send(http.MethodPost, "/posts/2/comments", `{"author":"Reader","body":"Second"}`)
+	if got := strings.TrimSpace(do(t, h, http.MethodGet, "/posts/1/comments", "").Body.String()); got != "[]" {
+		t.Errorf("pending comments are public: %s", got)
+	}
+
+	// Only moderators see the queue, across every post
+	expectStatus(t, do(t, h, http.MethodGet, "/admin/comments/pending", ""), http.StatusUnauthorized)
+	var pending []Comment
+	decode(t, send(http.MethodGet, "/admin/comments/pending", ""), &pending)
+	if len(pending) != 2 || pending[0].PostID != 1 || pending[1].PostID != 2 {
+		t.Fatalf("got pending %+v", pending)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/comments/1/approve", ""), http.StatusUnauthorized)
+	expectStatus(t, send(http.MethodPost, "/posts/1/comments/1/approve", ""), http.StatusOK)
+	expectStatus(t, send(http.MethodPost, "/posts/2/comments/1/approve", ""), http.StatusNotFound)
+	var comments []Comment
+	decode(t, do(t, h, http.MethodGet, "/posts/1/comments", ""), &comments)
+	if len(comments) != 1 || !comments[0].Approved {
+		t.Errorf("got %+v, want the approved comment", comments)
+	}
+
+	// Rejecting takes the replies with it
+	send(http.MethodPost, "/posts/1/comments", `{"author":"Reader","body":"Reply","parent_id":1}`)
+	expectStatus(t, send(http.MethodDelete, "/posts/2/comments/2", ""), http.StatusNoContent)
+	expectStatus(t, send(http.MethodDelete, "/posts/1/comments/1", ""), http.StatusNoContent)
+	expectStatus(t, send(http.MethodDelete, "/posts/1/comments/1", ""), http.StatusNotFound)
+	if rec := send(http.MethodGet, "/admin/comments/pending", ""); strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("still pending: %s", rec.Body.String())
+	}
+	expectStatus(t, send(http.MethodPost, "/posts/1/comments/x/approve", ""), http.StatusBadRequest)
+
+	// Drafts still need the key, to comment on as much as to read
+	expectStatus(t, send(http.MethodPost, "/posts", `{"title":"Draft","content":"Not yet","author":"Me"}`), http.StatusCreated)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/3/comments", `{"author":"Reader","body":"Hi"}`), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/3/comments?status=all", `{"author":"Reader","body":"Hi"}`), http.StatusUnauthorized)
+	expectStatus(t, send(http.MethodPost, "/posts/3/comments?status=all", `{"author":"Me","body":"Hi"}`), http.StatusCreated)
+	// Only the comment route is open, not everything under it
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/comments/1/approve/", ""), http.StatusUnauthorized)
+}
+
+func TestModerationNeedsKey(t *testing.T) {
+	h := setup(t)
+	rec := do(t, h, http.MethodPost, "/posts/1/comments", `{"author":"Reader","body":"First"}`)
+	expectStatus(t, rec, http.StatusCreated)
+
+	// Without a key anyone could moderate, so there's no moderating at all
+	expectStatus(t, do(t, h, http.MethodGet, "/admin/comments/pending", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/comments/1/approve", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodDelete, "/posts/1/comments/1", ""), http.StatusNotFound)
+	if got := strings.TrimSpace(do(t, h, http.MethodGet, "/posts/1/comments", "").Body.String()); got != "[]" {
+		t.Errorf("pending comments are public: %s", got)
+	}
+}
+
+func TestCommentOnMissingPost(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodPost, "/posts/99/comments", `{"author":"Reader","body":"Hello?"}`)
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestCommentReplies(t *testing.T) {
+	a := newTestAPI(config{maxCommentDepth: 2})
+	h := newRouter(a)
+
+	reply := func(parentID int) string {
+		return fmt.Sprintf(`{"author":"Reader","body":"Reply","parent_id":%d}`, parentID)
+	}
+	approvedComment(t, a, h, 1, reply(0)) // 1, top-level
+	approvedComment(t, a, h, 1, reply(1)) // 2, depth 1
+	approvedComment(t, a, h, 1, reply(2)) // 3, depth 2
+	approvedComment(t, a, h, 2, reply(0)) // 4, on another post
+	// 5, still pending
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/comments", reply(1)), http.StatusCreated)
+
+	// Too deep, missing, on another post or not approved yet
+	for _, parent := range []struct{ post, id int }{{1, 3}, {1, 99}, {1, 4}, {1, 5}} {
+		rec := do(t, h, http.MethodPost, fmt.Sprintf("/posts/%d/comments", parent.post), reply(parent.id))
+		expectStatus(t, rec, http.StatusBadRequest)
+	}
+
+	var comments []Comment
+	decode(t, do(t, h, http.MethodGet, "/posts/1/comments", ""), &comments)
+	var parents []int
+	for _, c := range comments {
+		parents = append(parents, c.ParentID)
+	}
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(parents, want) {
+		t.Errorf("got parents %v, want %v", parents, want)
+	}
+}
+
+func TestHead(t *testing.T) {
+	// A real server, it's net/http that leaves the body out
+	srv := httptest.NewServer(setup(t))
+	defer srv.Close()
+
+	for _, target := range []string{"/posts", "/posts/1"} {
+		get, err := http.Get(srv.URL + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(get.Body)
+		get.Body.Close()
+
+		head, err := http.Head(srv.URL + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headBody, _ := io.ReadAll(head.Body)
+		head.Body.Close()
+		if head.StatusCode != http.StatusOK || len(headBody) != 0 {
+			t.Errorf("HEAD %s = %d with %d bytes, want 200 and no body", target, head.StatusCode, len(headBody))
+		}
+		if head.ContentLength != int64(len(body)) {
+			t.Errorf("HEAD %s Content-Length = %d, want %d", target, head.ContentLength, len(body))
+		}
+		for _, name := range []string{"ETag", "Last-Modified", "Content-Type"} {
+			if got, want := head.Header.Get(name), get.Header.Get(name); got != want || got == "" {
+				t.Errorf("HEAD %s %s = %q, want %q", target, name, got, want)
+			}
+		}
+	}
+
+	head, err := http.Head(srv.URL + "/posts/99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(head.Body)
+	head.Body.Close()
+	if head.StatusCode != http.StatusNotFound || len(body) != 0 {
+		t.Errorf("HEAD /posts/99 = %d with %q, want 404 and no body", head.StatusCode, body)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := setupWith(t, config{corsOrigins: []string{"http://example.com"}})
+
+	req := httptest.NewRequest(http.MethodOptions, "/posts/1", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	expectStatus(t, rec, http.StatusNoContent)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "http://example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	h := setupWith(t, config{apiKey: "secret"})
+
+	for target, want := range map[string]string{
+		"/posts":          "GET, HEAD, POST, OPTIONS",
+		"/posts/1":        "GET, HEAD, PUT, PATCH, DELETE, OPTIONS",
+		"/posts/1/like":   "POST, OPTIONS",
+		"/posts/1/a/b/c/": "",
+	} {
+		rec := do(t, h, http.MethodOptions, target, "")
+		if want == "" {
+			expectStatus(t, rec, http.StatusNotFound)
+			continue
+		}
+		expectStatus(t, rec, http.StatusNoContent)
+		if got := rec.Header().Get("Allow"); got != want {
+			t.Errorf("OPTIONS %s: Allow = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	h := setup(t)
+
+	for _, target := range []string{"/posts", "/posts/99", "/up"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("%s: Content-Encoding = %q, want gzip", target, got)
+			continue
+		}
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Errorf("%s: reading gzip body: %v", target, err)
+			continue
+		}
+		if _, err := io.ReadAll(zr); err != nil {
+			t.Errorf("%s: reading gzip body: %v", target, err)
+		}
+	}
+}
+
+func TestPretty(t *testing.T) {
+	h := setupWith(t, config{cacheTTL: time.Minute, cacheSize: 10})
+
+	for _, target := range []string{"/posts", "/posts/1", "/posts/1/reactions", "/authors"} {
+		compact := do(t, h, http.MethodGet, target, "").Body.String()
+		rec := do(t, h, http.MethodGet, target+"?pretty=true", "")
+		expectStatus(t, rec, http.StatusOK)
+		var want bytes.Buffer
+		json.Indent(&want, []byte(compact), "", "  ")
+		if got := rec.Body.String(); got != want.String() || got == compact {
+			t.Errorf("%s: pretty body = %q, want %q", target, got, want.String())
+		}
+	}
+
+	// Indenting happens before compression, gzip just sees a longer body
+	req := httptest.NewRequest(http.MethodGet, "/posts/1?pretty=true", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	if !strings.HasPrefix(string(body), "{\n  \"id\": 1,") {
+		t.Errorf("gzipped pretty body = %q", body)
+	}
+
+	// The cache keeps the two apart
+	if body := do(t, h, http.MethodGet, "/posts", "").Body.String(); strings.Contains(body, "\n  ") {
+		t.Errorf("compact list came back indented: %q", body)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/posts", nil)
+	rec := httptest.NewRecorder()
+	writeJSON(rec, req, http.StatusCreated, map[string]int{"id": 1})
+	expectStatus(t, rec, http.StatusCreated)
+	if got := rec.Body.String(); got != `{"id":1}`+"\n" {
+		t.Errorf("body = %q", got)
+	}
+
+	// A value that can't be encoded is caught before the status goes out
+	rec = httptest.NewRecorder()
+	writeJSON(rec, req, http.StatusCreated, map[string]any{"bad": make(chan int)})
+	expectStatus(t, rec, http.StatusInternalServerError)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if apiErr.Code != codeInternal {
+		t.Errorf("error = %+v", apiErr)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Tags & <Escaping>","content":"a < b","author":"Me","published":true}`)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Draft","content":"Not yet","author":"Me"}`)
+
+	rec := do(t, h, http.MethodGet, "/feed.xml", "")
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/rss+xml") {
+		t.Errorf("Content-Type = %q, want application/rss+xml", got)
+	}
+
+	var feed rss
+	if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil {
+		t.Fatalf("decoding feed: %v", err)
+	}
+	items := feed.Channel.Items
+	if len(items) != 3 || items[0].Title != "Tags & <Escaping>" {
+		t.Fatalf("got %d items, first %q, want 3 with the newest post first", len(items), items[0].Title)
+	}
+}
+
+func TestTagFeed(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Ferris & <friends>","content":"a < b","author":"Me","tags":["rust & co"],"published":true}`)
+	for i := range feedSize {
+		do(t, h, http.MethodPost, "/posts", fmt.Sprintf(`{"title":"Go %d","content":"More Go","author":"Me","tags":["Go"],"published":true}`, i))
+	}
+	do(t, h, http.MethodPost, "/posts", `{"title":"Go draft","content":"Not yet","author":"Me","tags":["go"]}`)
+
+	feedOf := func(target string) rss {
+		t.Helper()
+		rec := do(t, h, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var feed rss
+		if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil {
+			t.Fatalf("decoding %s: %v", target, err)
+		}
+		return feed
+	}
+
+	// Capped like the main feed, newest first, any casing of the tag matches
+	feed := feedOf("/feed.xml?tag=go")
+	items := feed.Channel.Items
+	if len(items) != feedSize || items[0].Title != fmt.Sprintf("Go %d", feedSize-1) {
+		t.Fatalf("got %d items, first %q, want %d newest first", len(items), items[0].Title, feedSize)
+	}
+	if feed.Channel.Title != "Go Beyond JavaScript Blog: go" || feed.Channel.Link != "http://example.com/posts?tag=go" {
+		t.Errorf("channel is %q at %q, want it named after the tag", feed.Channel.Title, feed.Channel.Link)
+	}
+
+	// The tag is escaped in the channel like post contents are in the items
+	rec := do(t, h, http.MethodGet, "/feed.xml?tag="+url.QueryEscape("rust & co"), "")
+	if !strings.Contains(rec.Body.String(), "<title>Go Beyond JavaScript Blog: rust &amp; co</title>") {
+		t.Errorf("channel title isn't escaped in %s", rec.Body.String())
+	}
+	feed = feedOf("/feed.xml?tag=" + url.QueryEscape("rust & co"))
+	if len(feed.Channel.Items) != 1 || feed.Channel.Items[0].Title != "Ferris & <friends>" {
+		t.Errorf("got %+v, want only the rust post", feed.Channel.Items)
+	}
+
+	if items := feedOf("/feed.xml?tag=nothing").Channel.Items; len(items) != 0 {
+		t.Errorf("got %d items for an unused tag, want none", len(items))
+	}
+	if feed := feedOf("/feed.xml"); feed.Channel.Title != "Go Beyond JavaScript Blog" || len(feed.Channel.Items) != feedSize {
+		t.Errorf("got %q with %d items, want the full feed", feed.Channel.Title, len(feed.Channel.Items))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// With a request timeout, like the server's default, routing happens past it
+	h := setupWith(t, config{metrics: true, requestTimeout: time.Second})
+
+	do(t, h, http.MethodGet, "/posts/1", "")
+	do(t, h, http.MethodGet, "/posts/2", "")
+
+	rec := do(t, h, http.MethodGet, "/metrics", "")
+	expectStatus(t, rec, http.StatusOK)
+	body := rec.Body.String()
+
+	// Both lookups land on the route pattern, not their own paths
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/posts/{id}",status="200"} 2`,
+		"blog_posts 2",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	h := setupWith(t, config{rateLimit: 1, rateBurst: 2})
+
+	expectStatus(t, do(t, h, http.MethodGet, "/posts", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts", ""), http.StatusOK)
+
+	// The burst is used up, another request has to wait about a second
+	rec := do(t, h, http.MethodGet, "/posts", "")
+	expectStatus(t, rec, http.StatusTooManyRequests)
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	a := newTestAPI(config{rateLimit: 1, rateBurst: 1, trustProxy: true, apiKey: "secret"})
+	h := newRouter(a)
+
+	send := func(method, target, body string, forwarded ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "secret")
+		for _, fwd := range forwarded {
+			req.Header.Add("X-Forwarded-For", fwd)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The proxy appends the real IP, a made up one in front doesn't get a new bucket
+	expectStatus(t, send(http.MethodGet, "/posts", "", "1.1.1.1, 203.0.113.7"), http.StatusOK)
+	expectStatus(t, send(http.MethodGet, "/posts", "", "2.2.2.2, 203.0.113.7"), http.StatusTooManyRequests)
+	expectStatus(t, send(http.MethodGet, "/posts", "", "3.3.3.3", "203.0.113.7"), http.StatusTooManyRequests)
+
+	// Nor does it end up in the audit log
+	expectStatus(t, send(http.MethodDelete, "/posts/1", "", "4.4.4.4, 198.51.100.2"), http.StatusNoContent)
+	var page auditPage
+	decode(t, send(http.MethodGet, "/admin/audit", "", "198.51.100.3"), &page)
+	if len(page.Data) != 1 || page.Data[0].IP != "198.51.100.2" {
+		t.Errorf("got %+v, want the delete from the IP the proxy saw", page.Data)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(1, 1, false)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("first request limited")
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Fatal("second request allowed with an empty bucket")
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatal("other IP limited")
+	}
+
+	// After a second the bucket has a token again, and idle buckets get swept
+	now = now.Add(2 * time.Minute)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("request limited after the bucket refilled")
+	}
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("idle bucket wasn't swept")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			w.Write([]byte("done"))
+		}
+	})
+	h := requestTimeout(10*time.Millisecond, "/stream")(slow)
+
+	rec := do(t, h, http.MethodGet, "/slow", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if apiErr.Code != codeTimeout {
+		t.Errorf("code = %q, want %q", apiErr.Code, codeTimeout)
+	}
+
+	// Skipped paths run as long as they need
+	rec = do(t, h, http.MethodGet, "/stream", "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "done" {
+		t.Errorf("body = %q, want done", rec.Body.String())
+	}
+
+	// Quick requests go through untouched
+	h = setupWith(t, config{requestTimeout: time.Second})
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1", ""), http.StatusOK)
+
+	// The default gets its 503 out before the write timeout closes the connection
+	cfg := defaultConfig(t)
+	if cfg.requestTimeout >= cfg.writeTimeout {
+		t.Errorf("default request timeout %v isn't shorter than the write timeout %v", cfg.requestTimeout, cfg.writeTimeout)
+	}
+	for _, args := range [][]string{{"-request-timeout", "10s"}, {"-request-timeout", "20s", "-write-timeout", "15s"}} {
+		fs := flag.NewFlagSet("blog-api", flag.ContinueOnError)
+		if _, err := parseConfig(fs, args); err == nil {
+			t.Errorf("%v: want an error for a request timeout past the write timeout", args)
+		}
+	}
+	// Either limit off is fine
+	for _, args := range [][]string{{"-request-timeout", "20s", "-write-timeout", "0"}, {"-request-timeout", "0"}} {
+		fs := flag.NewFlagSet("blog-api", flag.ContinueOnError)
+		if _, err := parseConfig(fs, args); err != nil {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+}
+
+// slowStore is a PostStore whose creates take delay, like a busy database
+type slowStore struct {
+	PostStore
+	delay time.Duration
+}
+
+func (s slowStore) Create(ctx context.Context, p Post) (Post, error) {
+	time.Sleep(s.delay)
+	return s.PostStore.Create(ctx, p)
+}
+
+func TestTimeoutInvalidatesCache(t *testing.T) {
+	a := newTestAPI(config{cacheTTL: time.Minute, cacheSize: 10, requestTimeout: 20 * time.Millisecond})
+	a.store = slowStore{a.store, 200 * time.Millisecond}
+	h := newRouter(a)
+	total := func() int {
+		var list PostList
+		decode(t, do(t, h, http.MethodGet, "/posts", ""), &list)
+		return list.Total
+	}
+	before := total()
+
+	// The create outlives its 503, a list cached meanwhile is still the old one
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"Slow","content":"Hi","author":"Me","published":true}`), http.StatusServiceUnavailable)
+	if got := total(); got != before {
+		t.Fatalf("total = %d before the create landed, want %d", got, before)
+	}
+	// Once it lands the cache is dropped
+	waitFor(t, func() bool { return total() == before+1 })
+}
+
+func TestAPIKey(t *testing.T) {
+	h := setupWith(t, config{apiKey: "secret"})
+	post := `{"title":"Hi","content":"There","author":"Me"}`
+
+	send := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/posts", strings.NewReader(post))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	expectStatus(t, send("", ""), http.StatusUnauthorized)
+	expectStatus(t, send("X-API-Key", "wrong"), http.StatusUnauthorized)
+	expectStatus(t, send("Authorization", "Bearer wrong"), http.StatusUnauthorized)
+	expectStatus(t, send("X-API-Key", "secret"), http.StatusCreated)
+	expectStatus(t, send("Authorization", "Bearer secret"), http.StatusCreated)
+
+	// Reads don't need the key
+	expectStatus(t, do(t, h, http.MethodGet, "/posts", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodDelete, "/posts/1", ""), http.StatusUnauthorized)
+}
+
+func TestReset(t *testing.T) {
+	expectStatus(t, do(t, setup(t), http.MethodPost, "/admin/reset", ""), http.StatusNotFound)
+
+	h := setupWith(t, config{dev: true})
+	do(t, h, http.MethodDelete, "/posts/1", "")
+	do(t, h, http.MethodPost, "/posts", `{"title":"Hi","content":"There","author":"Me"}`)
+	do(t, h, http.MethodPost, "/posts/2/comments", `{"author":"Me","body":"Nice"}`)
+
+	expectStatus(t, do(t, h, http.MethodPost, "/admin/reset", ""), http.StatusNoContent)
+
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts?include_drafts=true", ""), &list)
+	if list.Total != 2 || list.Data[0].ID != 1 {
+		t.Errorf("after reset got %+v, want the two sample posts", list.Data)
+	}
+	var comments []Comment
+	decode(t, do(t, h, http.MethodGet, "/posts/2/comments", ""), &comments)
+	if len(comments) != 0 {
+		t.Errorf("after reset got %d comments, want none", len(comments))
+	}
+
+	// Numbering starts over too
+	var post Post
+	decode(t, do(t, h, http.MethodPost, "/posts", `{"title":"Again","content":"There","author":"Me"}`), &post)
+	if post.ID != 3 {
+		t.Errorf("new post ID = %d, want 3", post.ID)
+	}
+}
+
+func TestXML(t *testing.T) {
+	h := setup(t)
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/posts/1", "application/xml")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("Content-Type = %q, want application/xml", ct)
+	}
+	var post Post
+	if err := xml.Unmarshal(rec.Body.Bytes(), &post); err != nil {
+		t.Fatalf("decoding XML: %v", err)
+	}
+	if post.ID != 1 || len(post.Tags) != 2 {
+		t.Errorf("got %+v, want post 1 with its tags", post)
+	}
+
+	rec = get("/posts", "text/html, application/xml;q=0.9, */*;q=0.1")
+	var list PostList
+	if err := xml.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decoding XML list: %v", err)
+	}
+	if list.Total != 2 || len(list.Data) != 2 {
+		t.Errorf("got %d of %d posts, want 2 of 2", len(list.Data), list.Total)
+	}
+
+	// Wildcards still get JSON
+	rec = get("/posts", "*/*")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	expectStatus(t, get("/posts", "text/csv"), http.StatusNotAcceptable)
+}
+
+func TestExportCSV(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Lists, quotes","content":"Line one\nsaid \"hi\"","author":"Gopher","published":true}`)
+
+	rec := do(t, h, http.MethodGet, "/posts.csv?author=gopher", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	want := [][]string{
+		{"id", "title", "content", "author"},
+		{"1", "Welcome to Go", "Go is awesome for backend development!", "Gopher"},
+		{"3", "Lists, quotes", "Line one\nsaid \"hi\"", "Gopher"},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", rows, want)
+	}
+}
+
+func TestSortPosts(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	posts := []Post{
+		{ID: 1, Title: "b", Author: "Zoe", CreatedAt: day(1)},
+		{ID: 2, Title: "a", Author: "ann", CreatedAt: day(1)},
+		{ID: 3, Title: "c", Author: "Ann", CreatedAt: day(3)},
+		{ID: 4, Title: "a", Author: "Zoe", CreatedAt: day(2)},
+	}
+	for keys, want := range map[string][]int{
+		"":                   {1, 2, 3, 4},
+		"-id":                {4, 3, 2, 1},
+		"author,-created_at": {3, 2, 4, 1},
+		"title,-author":      {4, 2, 1, 3},
+		" -created_at , id ": {3, 4, 1, 2},
+	} {
+		sorted := slices.Clone(posts)
+		if err := sortPosts(sorted, keys); err != nil {
+			t.Fatalf("%q: %v", keys, err)
+		}
+		got := []int{}
+		for _, p := range sorted {
+			got = append(got, p.ID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("sort %q = %v, want %v", keys, got, want)
+		}
+	}
+
+	h := setup(t)
+	rec := do(t, h, http.MethodGet, "/posts?sort=author,-likes", "")
+	expectStatus(t, rec, http.StatusBadRequest)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if !strings.Contains(apiErr.Error, `"-likes"`) {
+		t.Errorf("error %q doesn't name the bad key", apiErr.Error)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	for _, tc := range []struct{ s, q, want string }{
+		{"Go is great, GO!", "go", "<mark>Go</mark> is great, <mark>GO</mark>!"},
+		{"Ünïcödé ÜBER alles", "über", "Ünïcödé <mark>ÜBER</mark> alles"},
+		{"日本語の本", "本", "日<mark>本</mark>語の<mark>本</mark>"},
+		{"aaaaa", "aa", "<mark>aa</mark><mark>aa</mark>a"},
+		{"nothing here", "go", "nothing here"},
+		{"Go", "", "Go"},
+		// Only text is marked, never a tag, an attribute or part of an entity
+		{`<a href="https://go.dev">Go</a> &amp; more`, "go", `<a href="https://go.dev"><mark>Go</mark></a> &amp; more`},
+		{"Tom &amp; Jerry's &lt;b&gt;", "amp", "Tom &amp; Jerry's &lt;b&gt;"},
+		{"Tom &amp; Jerry&#39;s", "& jerry's", "Tom <mark>&amp; Jerry&#39;s</mark>"},
+		{"<b>G</b>o", "go", "<b>G</b>o"},
+	} {
+		if got := highlight(tc.s, tc.q); got != tc.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", tc.s, tc.q, got, tc.want)
+		}
+	}
+
+	h := setup(t)
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts?q=GO&highlight=true", ""), &list)
+	if len(list.Data) == 0 || list.Data[0].Title != "Welcome to <mark>Go</mark>" || !strings.HasPrefix(list.Data[0].Excerpt, "<mark>Go</mark> is") {
+		t.Errorf("highlighted posts = %+v", list.Data)
+	}
+
+	// Off by default, and never stored
+	decode(t, do(t, h, http.MethodGet, "/posts?q=go", ""), &list)
+	if strings.Contains(list.Data[0].Title, "<mark>") {
+		t.Errorf("title %q marked without ?highlight=true", list.Data[0].Title)
+	}
+	var post Post
+	decode(t, do(t, h, http.MethodGet, "/posts/1", ""), &post)
+	if post.Title != "Welcome to Go" {
+		t.Errorf("stored title = %q", post.Title)
+	}
+}
+
+func TestAccentInsensitiveSearch(t *testing.T) {
+	h := setup(t)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"Un Café à Paris","content":"Crème brûlée","author":"Zoë","published":true}`), http.StatusCreated)
+	// The same accent as a combining mark after a plain letter
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"Decomposed","content":"cafe\u0301 noir","author":"Me","published":true}`), http.StatusCreated)
+
+	for _, tc := range []struct {
+		q     string
+		fuzzy bool
+		want  []int
+	}{
+		{q: "cafe", want: []int{3, 4}},
+		{q: "CAFÉ", want: []int{3, 4}},
+		{q: "creme brulee", want: []int{3}},
+		{q: "crème", want: []int{3}},
+		{q: "tea", want: []int{}},
+		{q: "cafes", want: []int{}},
+		{q: "cafes", fuzzy: true, want: []int{3, 4}},
+	} {
+		query := url.Values{"q": {tc.q}, "sort": {"id"}}
+		if tc.fuzzy {
+			query.Set("fuzzy", "true")
+		}
+		var list PostList
+		decode(t, do(t, h, http.MethodGet, "/posts?"+query.Encode(), ""), &list)
+		got := []int{}
+		for _, post := range list.Data {
+			got = append(got, post.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got posts %v, want %v", query.Encode(), got, tc.want)
+		}
+	}
+
+	// Only the comparison is folded, the post keeps its accents
+	var post Post
+	decode(t, do(t, h, http.MethodGet, "/posts/3", ""), &post)
+	if post.Title != "Un Café à Paris" || post.Content != "Crème brûlée" {
+		t.Errorf("stored post = %+v", post)
+	}
+
+	for _, tc := range []struct{ s, q, want string }{
+		{"Un Café", "cafe", "Un <mark>Café</mark>"},
+		{"cafe\u0301!", "CAFÉ", "<mark>cafe\u0301</mark>!"},
+		{"naïve", "naive", "<mark>naïve</mark>"},
+		// Letters past Latin Extended-A fold too, like Romanian and Vietnamese ones
+		{"Știință și țară", "stiinta si tara", "<mark>Știință și țară</mark>"},
+		{"Phở ngon", "pho", "<mark>Phở</mark> ngon"},
+		{"Người Việt", "nguoi viet", "<mark>Người Việt</mark>"},
+	} {
+		if got := highlight(tc.s, tc.q); got != tc.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", tc.s, tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	h := setup(t)
+
+	// Exact search doesn't forgive the typo
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts?q=relaible", ""), &list)
+	if list.Total != 0 {
+		t.Errorf("exact search found %d posts, want 0", list.Total)
+	}
+
+	decode(t, do(t, h, http.MethodGet, "/posts?q=relaible+simpel&fuzzy=true", ""), &list)
+	if list.Total != 1 || list.Data[0].ID != 2 || list.Data[0].Score <= 0 {
+		t.Fatalf("fuzzy search got %+v, want post 2 with a score", list.Data)
+	}
+
+	// The exact spelling ranks above the typo even though it was posted later
+	do(t, h, http.MethodPost, "/posts", `{"title":"Concurency","content":"Goroutines","author":"Me","published":true}`)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Concurrency","content":"Channels","author":"Me","published":true}`)
+	decode(t, do(t, h, http.MethodGet, "/posts?q=concurrency&fuzzy=true", ""), &list)
+	if list.Total != 2 || list.Data[0].ID != 4 || list.Data[0].Score <= list.Data[1].Score {
+		t.Errorf("fuzzy search got %+v, want post 4 ranked first", list.Data)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"go", "", 2},
+		{"kitten", "sitting", 3},
+		{"reliable", "relaible", 2},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownRoutes(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/nope", "")
+	expectStatus(t, rec, http.StatusNotFound)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if apiErr.Status != http.StatusNotFound || apiErr.Code != codeNotFound {
+		t.Errorf("got %+v, want a JSON 404", apiErr)
+	}
+
+	rec = do(t, h, http.MethodPost, "/posts/1", "")
+	expectStatus(t, rec, http.StatusMethodNotAllowed)
+	decode(t, rec, &apiErr)
+	if apiErr.Status != http.StatusMethodNotAllowed {
+		t.Errorf("got %+v, want a JSON 405", apiErr)
+	}
+	if got := strings.Join(rec.Header().Values("Allow"), ", "); got != "GET, HEAD, PUT, PATCH, DELETE" {
+		t.Errorf("Allow = %q, want GET, HEAD, PUT, PATCH, DELETE", got)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	file, err := newRotatingFile(path, 1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAPI(config{})
+	a.accessLog = slog.New(requestIDHandler{slog.NewJSONHandler(file, nil)})
+	h := newRouter(a)
+
+	for i := 0; i < 20; i++ {
+		do(t, h, http.MethodGet, "/posts/1", "")
+	}
+	do(t, h, http.MethodGet, "/posts/99", "")
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the newest two old files are kept, none past the size
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1024 {
+			t.Errorf("%s is %d bytes, want at most 1024", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("got a third backup, err %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var last struct {
+		Method    string `json:"method"`
+		Path      string `json:"path"`
+		Status    int    `json:"status"`
+		RequestID string `json:"request_id"`
+		Remote    string `json:"remote"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("last line %q: %v", lines[len(lines)-1], err)
+	}
+	if last.Method != http.MethodGet || last.Path != "/posts/99" || last.Status != http.StatusNotFound || last.RequestID == "" || last.Remote == "" {
+		t.Errorf("last line = %+v", last)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/posts", "")
+	if rec.Header().Get("X-Request-ID") == "" {
+		t.Error("no X-Request-ID in the response")
+	}
+
+	// A client's own ID is kept
+	req := httptest.NewRequest(http.MethodGet, "/posts", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("X-Request-ID = %q, want abc-123", got)
+	}
+
+	// and ends up on log lines for that request
+	var buf bytes.Buffer
+	logger, err := newLogger("json", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "abc-123")
+	logger.InfoContext(ctx, "hello")
+	if !strings.Contains(buf.String(), `"request_id":"abc-123"`) {
+		t.Errorf("log line %q has no request ID", buf.String())
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := setup(t)
+
+	var post Post
+	decode(t, do(t, h, http.MethodPost, "/posts", `{"title":"Soon","content":"Not yet","author":"Me"}`), &post)
+
+	rec := do(t, h, http.MethodPost, "/posts/3/publish", "")
+	expectStatus(t, rec, http.StatusOK)
+	var published Post
+	decode(t, rec, &published)
+	if !published.Published || !published.UpdatedAt.After(post.UpdatedAt) {
+		t.Errorf("got %+v, want it published with a newer UpdatedAt", published)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/3", ""), http.StatusOK)
+
+	// Publishing again is a no-op
+	rec = do(t, h, http.MethodPost, "/posts/3/publish", "")
+	expectStatus(t, rec, http.StatusOK)
+	var again Post
+	decode(t, rec, &again)
+	if again.Version != published.Version {
+		t.Errorf("version changed from %d to %d on a no-op publish", published.Version, again.Version)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/3/unpublish", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/3", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/99/publish", ""), http.StatusNotFound)
+}
+
+func TestReadingStats(t *testing.T) {
+	tests := []struct {
+		content        string
+		words, minutes int
+	}{
+		{"", 0, 0},
+		{"  one\ttwo\nthree ", 3, 1},
+		{strings.Repeat("word ", 200), 200, 1},
+		{strings.Repeat("word ", 201), 201, 2},
+		// Only the text counts, not tags, attributes or scripts, an entity is one character
+		{`<p>One <a href="https://go.dev" title="a b c">two</a></p><p>three</p> &amp; <script>x y</script>`, 4, 1},
+		{"one<br>two", 2, 1},
+	}
+	for _, tt := range tests {
+		post := Post{Content: tt.content}
+		setReadingStats(&post)
+		if post.WordCount != tt.words || post.ReadingTimeMinutes != tt.minutes {
+			t.Errorf("stats for %q = %d words, %d min, want %d, %d", tt.content, post.WordCount, post.ReadingTimeMinutes, tt.words, tt.minutes)
+		}
+	}
+
+	// Edits update the numbers
+	h := setup(t)
+	var post Post
+	decode(t, do(t, h, http.MethodPatch, "/posts/1", `{"content":"`+strings.Repeat("word ", 450)+`"}`), &post)
+	if post.WordCount != 450 || post.ReadingTimeMinutes != 3 {
+		t.Errorf("after edit got %d words, %d min, want 450, 3", post.WordCount, post.ReadingTimeMinutes)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		content string
+		n       int
+		want    string
+	}{
+		{"", 10, ""},
+		{"Short and sweet", 20, "Short and sweet"},
+		{"Go is awesome for backend development", 15, "Go is awesome…"},
+		{"Line one.\n\nLine two", 12, "Line one…"},
+		{"Supercalifragilistic", 5, "Super…"},
+		{"héhé ünïcödé wörds", 14, "héhé ünïcödé…"},
+		// Cut from the text, never inside a tag or an entity, and escaped again
+		{`<p>Go <a href="https://go.dev/doc/tutorial">is fun</a></p>`, 8, "Go is…"},
+		{"Tom &amp; Jerry&#39;s show", 15, "Tom &amp; Jerry&#39;s…"},
+		{"&lt;b&gt; stays text", 30, "&lt;b&gt; stays text"},
+	}
+	for _, tt := range tests {
+		got := excerpt(tt.content, tt.n)
+		if got != tt.want {
+			t.Errorf("excerpt(%q, %d) = %q, want %q", tt.content, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("excerpt(%q, %d) isn't valid UTF-8", tt.content, tt.n)
+		}
+	}
+
+	// The list has excerpts, a single post has the full content
+	h := setup(t)
+	long := strings.Repeat("lorem ipsum ", 30)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Long","content":"`+long+`","author":"Me","published":true}`)
+
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts?q=lorem", ""), &list)
+	if got := list.Data[0]; got.Content != "" || !strings.HasSuffix(got.Excerpt, "…") || len([]rune(got.Excerpt)) > excerptLength+1 {
+		t.Errorf("list post has content %q and excerpt %q, want only a short excerpt", got.Content, got.Excerpt)
+	}
+	var post Post
+	decode(t, do(t, h, http.MethodGet, "/posts/3", ""), &post)
+	if post.Content != long {
+		t.Errorf("single post content = %q, want the full content", post.Content)
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	h := setup(t)
+
+	rec := do(t, h, http.MethodGet, "/posts/1", "")
+	modified := rec.Header().Get("Last-Modified")
+	if _, err := http.ParseTime(modified); err != nil {
+		t.Fatalf("Last-Modified = %q: %v", modified, err)
+	}
+
+	get := func(target, since string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("If-Modified-Since", since)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The header has whole seconds only, so the same value has to count as current
+	rec = get("/posts/1", modified)
+	expectStatus(t, rec, http.StatusNotModified)
+	if rec.Body.Len() != 0 {
+		t.Errorf("304 came with a body: %q", rec.Body.String())
+	}
+
+	// The list goes by its newest post
+	list := do(t, h, http.MethodGet, "/posts", "")
+	expectStatus(t, get("/posts", list.Header().Get("Last-Modified")), http.StatusNotModified)
+	expectStatus(t, get("/posts", modified), http.StatusOK)
+
+	// After an edit the old copy is stale
+	do(t, h, http.MethodPatch, "/posts/1", `{"title":"Changed"}`)
+	expectStatus(t, get("/posts/1", modified), http.StatusOK)
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	h := setup(t)
+
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("If-None-Match", etag)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, target := range []string{"/posts", "/posts/1"} {
+		etag := do(t, h, http.MethodGet, target, "").Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: no ETag", target)
+		}
+		rec := get(target, `"other", `+etag)
+		expectStatus(t, rec, http.StatusNotModified)
+		if rec.Body.Len() != 0 {
+			t.Errorf("%s: 304 came with a body", target)
+		}
+	}
+
+	// Deleting a post changes the list body, so the old list ETag no longer matches
+	etag := do(t, h, http.MethodGet, "/posts", "").Header().Get("ETag")
+	do(t, h, http.MethodDelete, "/posts/1", "")
+	expectStatus(t, get("/posts", etag), http.StatusOK)
+
+	// Different filters are different bodies
+	if other := do(t, h, http.MethodGet, "/posts?q=go", "").Header().Get("ETag"); other == etag {
+		t.Error("filtered list has the same ETag as the full one")
+	}
+
+	// A like changes a post's body but not its version, the old copy is stale all the same
+	for _, target := range []string{"/posts/2", "/posts/slug/why-choose-go", "/posts/2/html"} {
+		etag := do(t, h, http.MethodGet, target, "").Header().Get("ETag")
+		do(t, h, http.MethodPost, "/posts/2/like", "")
+		if target == "/posts/2/html" {
+			// The rendered content doesn't show likes
+			expectStatus(t, get(target, etag), http.StatusNotModified)
+			continue
+		}
+		expectStatus(t, get(target, etag), http.StatusOK)
+	}
+
+	// Each representation of a post has its own ETag
+	etag = do(t, h, http.MethodGet, "/posts/2", "").Header().Get("ETag")
+	if other := do(t, h, http.MethodGet, "/posts/2?fields=id,title", "").Header().Get("ETag"); other == etag {
+		t.Error("?fields= has the same ETag as the whole post")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/posts/2", nil)
+	req.Header.Set("Accept", "application/xml")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if other := rec.Header().Get("ETag"); other == etag {
+		t.Error("XML has the same ETag as JSON")
+	}
+}
+
+func TestListCache(t *testing.T) {
+	h := setupWith(t, config{cacheTTL: time.Minute, cacheSize: 10})
+
+	if got := do(t, h, http.MethodGet, "/posts?limit=5&q=go", "").Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("first request X-Cache = %q, want MISS", got)
+	}
+	// Same params in another order are the same list
+	rec := do(t, h, http.MethodGet, "/posts?q=go&limit=5", "")
+	if got := rec.Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("second request X-Cache = %q, want HIT", got)
+	}
+	var list PostList
+	decode(t, rec, &list)
+	if list.Total != 2 {
+		t.Errorf("cached list has %d posts, want 2", list.Total)
+	}
+
+	// A write makes the cached list stale
+	do(t, h, http.MethodDelete, "/posts/1", "")
+	rec = do(t, h, http.MethodGet, "/posts?q=go&limit=5", "")
+	if got := rec.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("after a write X-Cache = %q, want MISS", got)
+	}
+	decode(t, rec, &list)
+	if list.Total != 1 {
+		t.Errorf("list after delete has %d posts, want 1", list.Total)
+	}
+}
+
+func TestListCacheEviction(t *testing.T) {
+	now := time.Now()
+	c := newListCache(time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.put(cacheEntry{key: "a"})
+	c.put(cacheEntry{key: "b"})
+	c.get("a") // a is now the most recently used
+	c.put(cacheEntry{key: "c"})
+
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry wasn't evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.get("c"); ok {
+		t.Error("expired entry was returned")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	h := setup(t)
+	for i := 0; i < 3; i++ {
+		do(t, h, http.MethodPost, "/posts", `{"title":"More","content":"Posts","author":"Me","published":true}`)
+	}
+
+	// Walk all five posts two at a time, deleting one along the way
+	var ids []int
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		var list PostList
+		decode(t, do(t, h, http.MethodGet, "/posts?limit=2&cursor="+cursor, ""), &list)
+		for _, post := range list.Data {
+			ids = append(ids, post.ID)
+		}
+		if page == 0 {
+			do(t, h, http.MethodDelete, "/posts/1", "")
+		}
+		if list.NextCursor == "" {
+			break
+		}
+		cursor = list.NextCursor
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		t.Errorf("walked IDs %v, want [1 2 3 4 5]", ids)
+	}
+
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?cursor=!!", ""), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?sort=title&cursor="+encodeCursor(1), ""), http.StatusBadRequest)
+}
+
+func TestLinkHeader(t *testing.T) {
+	h := setup(t)
+	for i := 0; i < 3; i++ {
+		do(t, h, http.MethodPost, "/posts", `{"title":"More","content":"Posts","author":"Me","published":true}`)
+	}
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/posts?limit=2&author=me", []string{
+			`</posts?author=me&limit=2&offset=0>; rel="first"`,
+			`</posts?author=me&limit=2&offset=2>; rel="next"`,
+			`</posts?author=me&limit=2&offset=2>; rel="last"`,
+		}},
+		{"/posts?limit=2&offset=2", []string{
+			`</posts?limit=2&offset=0>; rel="first"`,
+			`</posts?limit=2&offset=0>; rel="prev"`,
+			`</posts?limit=2&offset=4>; rel="next"`,
+			`</posts?limit=2&offset=4>; rel="last"`,
+		}},
+		{"/posts?limit=2&offset=4", []string{
+			`</posts?limit=2&offset=0>; rel="first"`,
+			`</posts?limit=2&offset=2>; rel="prev"`,
+			`</posts?limit=2&offset=4>; rel="last"`,
+		}},
+	}
+	for _, tt := range tests {
+		got := do(t, h, http.MethodGet, tt.target, "").Header().Get("Link")
+		if want := strings.Join(tt.want, ", "); got != want {
+			t.Errorf("%s: Link = %s\nwant %s", tt.target, got, want)
+		}
+	}
+}
+
+func TestGetPostsByIDs(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Draft","content":"Hidden","author":"Me"}`)
+
+	// Requested order is kept, missing, repeated and draft IDs are skipped
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts?ids=2,99,1,2,3", ""), &list)
+	var ids []int
+	for _, post := range list.Data {
+		ids = append(ids, post.ID)
+	}
+	if fmt.Sprint(ids) != "[2 1]" || list.Total != 2 {
+		t.Errorf("got IDs %v (total %d), want [2 1]", ids, list.Total)
+	}
+
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?ids=1,x", ""), http.StatusBadRequest)
+	tooMany := strings.TrimSuffix(strings.Repeat("1,", maxBatchIDs+1), ",")
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?ids="+tooMany, ""), http.StatusBadRequest)
+}
+
+func TestWebhooks(t *testing.T) {
+	events := make(chan event, 10)
+	failures := 0
+	var mu sync.Mutex
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first delivery to check it's retried
+		mu.Lock()
+		defer mu.Unlock()
+		if failures == 0 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e event
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer hook.Close()
+
+	a := newTestAPI(config{})
+	a.webhooks = newWebhooks([]string{hook.URL})
+	a.webhooks.backoff = time.Millisecond
+	h := newRouter(a)
+
+	do(t, h, http.MethodPost, "/posts", `{"title":"Hi","content":"There","author":"Me"}`)
+	do(t, h, http.MethodDelete, "/posts/1", "")
+
+	// Deliveries run concurrently, so they may arrive in any order
+	got := map[string]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			got[e.Event] = e.Post.ID
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only got %v", got)
+		}
+	}
+	if got[eventCreated] != 3 || got[eventDeleted] != 1 {
+		t.Errorf("got events %v, want post 3 created and post 1 deleted", got)
+	}
+
+	// Deliveries outlive the request they came from
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.webhooks.send(ctx, event{Event: eventUpdated, Post: Post{ID: 2}})
+	select {
+	case e := <-events:
+		if e.Event != eventUpdated {
+			t.Errorf("got event %q, want %q", e.Event, eventUpdated)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a canceled request's event wasn't delivered")
+	}
+}
+
+func TestWebhooksShutdown(t *testing.T) {
+	canceled := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client hanging up once the body is read
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer hook.Close()
+
+	hooks := newWebhooks([]string{hook.URL})
+	hooks.send(context.Background(), event{Event: eventCreated})
+
+	// Once shutdown stops waiting, the delivery still hanging is dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	hooks.wait(ctx)
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery wasn't canceled")
+	}
+	hooks.wg.Wait()
+}
+
+func TestStoreContext(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	// A canceled request doesn't reach the database
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sqlite.Create(ctx, Post{Title: "New", Content: "Hi", Author: "Me"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Create with a canceled context = %v", err)
+	}
+	if _, err := sqlite.AddLikes(ctx, 1, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddLikes with a canceled context = %v", err)
+	}
+	if post, _ := sqlite.Get(context.Background(), 1); post.Likes != 0 {
+		t.Errorf("likes = %d, want 0", post.Likes)
+	}
+
+	// The handlers pass the request's context down
+	a := newTestAPI(config{})
+	a.store = sqlite
+	h := newRouter(a)
+	req := httptest.NewRequest(http.MethodPost, "/posts", strings.NewReader(`{"title":"New","content":"Hi","author":"Me"}`)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusInternalServerError)
+	if n := sqlite.Count(context.Background(), func(Post) bool { return true }); n != 2 {
+		t.Errorf("got %d posts, want the 2 samples", n)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	a := newTestAPI(config{})
+	srv := httptest.NewUnstartedServer(newRouter(a))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/posts/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// The headers arrive after subscribing, so the change can't be missed
+	waitFor(t, func() bool { return a.events.subscribers() == 1 })
+
+	// The stream outlives the server's write timeout
+	time.Sleep(3 * srv.Config.WriteTimeout)
+	del, _ := http.NewRequest(http.MethodDelete, srv.URL+"/posts/2", nil)
+	if resp, err := http.DefaultClient.Do(del); err != nil {
+		t.Fatal(err)
+	} else {
+		resp.Body.Close()
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	for _, want := range []string{"event: post.deleted", `data: {"event":"post.deleted","post":{"id":2,`} {
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, want) {
+				t.Fatalf("got line %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %q line", want)
+		}
+	}
+
+	// Hanging up unsubscribes
+	cancel()
+	waitFor(t, func() bool { return a.events.subscribers() == 0 })
+}
+
+func TestEventStreamDrafts(t *testing.T) {
+	srv := httptest.NewServer(setupWith(t, config{apiKey: "secret"}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// stream returns the event names a stream gets, the headers arrive after subscribing
+	stream := func(target, key string) <-chan string {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+target, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", target, resp.StatusCode)
+		}
+		names := make(chan string, 10)
+		go func() {
+			defer resp.Body.Close()
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+					names <- name
+				}
+			}
+		}()
+		return names
+	}
+	public, all := stream("/posts/events", ""), stream("/posts/events?status=all", "secret")
+
+	// A draft is created, then a published post deleted
+	for _, change := range []struct{ method, target, body string }{
+		{http.MethodPost, "/posts", `{"title":"Draft","content":"Secret","author":"Me"}`},
+		{http.MethodDelete, "/posts/2", ""},
+	} {
+		req, _ := http.NewRequest(change.method, srv.URL+change.target, strings.NewReader(change.body))
+		req.Header.Set("X-API-Key", "secret")
+		if resp, err := http.DefaultClient.Do(req); err != nil {
+			t.Fatal(err)
+		} else {
+			resp.Body.Close()
+		}
+	}
+
+	for _, tc := range []struct {
+		names <-chan string
+		want  []string
+	}{
+		{public, []string{eventDeleted}},
+		{all, []string{eventCreated, eventDeleted}},
+	} {
+		for _, want := range tc.want {
+			select {
+			case name := <-tc.names:
+				if name != want {
+					t.Errorf("got event %q, want %q", name, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no %q event", want)
+			}
+		}
+	}
+
+	// Asking for drafts needs the key, like on the lists
+	resp, err := http.Get(srv.URL + "/posts/events?status=all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestLikePost(t *testing.T) {
+	h := setup(t)
+
+	// Likes from many clients at once must all count
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(t, h, http.MethodPost, "/posts/1/like", "")
+		}()
+	}
+	wg.Wait()
+
+	rec := do(t, h, http.MethodPost, "/posts/1/like?delta=-1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var got map[string]int
+	decode(t, rec, &got)
+	if got["likes"] != 49 {
+		t.Errorf("likes = %d, want 49", got["likes"])
+	}
+
+	// Unliking stops at zero, and editing the post keeps its likes
+	do(t, h, http.MethodPost, "/posts/2/like?delta=-1", "")
+	do(t, h, http.MethodPatch, "/posts/1", `{"title":"Still liked"}`)
+	do(t, h, http.MethodPut, "/posts/1", `{"title":"Replaced","content":"Hi","author":"Me","published":true,"likes":1000}`)
+	var post Post
+	decode(t, do(t, h, http.MethodGet, "/posts/1", ""), &post)
+	var other Post
+	decode(t, do(t, h, http.MethodGet, "/posts/2", ""), &other)
+	if post.Likes != 49 || other.Likes != 0 {
+		t.Errorf("likes = %d and %d, want 49 and 0", post.Likes, other.Likes)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/like?delta=5", ""), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/99/like", ""), http.StatusNotFound)
+}
+
+func TestReactions(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	mem := NewMemStore()
+	initializeSampleData(mem)
+
+	for name, store := range map[string]PostStore{"mem": mem, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			a := newTestAPI(config{})
+			a.store = store
+			h := newRouter(a)
+
+			// Reactions from many clients at once must all count
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					do(t, h, http.MethodPost, "/posts/1/reactions", `{"emoji":"👍"}`)
+				}()
+			}
+			wg.Wait()
+
+			// A heart without the variation selector is the same heart
+			do(t, h, http.MethodPost, "/posts/1/reactions", `{"emoji":"❤️"}`)
+			rec := do(t, h, http.MethodPost, "/posts/1/reactions", `{"emoji":"❤"}`)
+			expectStatus(t, rec, http.StatusOK)
+			var got reactionCounts
+			decode(t, rec, &got)
+			want := map[string]int{"👍": 50, "❤️": 2}
+			if !reflect.DeepEqual(got.Reactions, want) {
+				t.Errorf("reactions = %v, want %v", got.Reactions, want)
+			}
+
+			// Editing the post keeps them, clients can't set them
+			do(t, h, http.MethodPut, "/posts/1", `{"title":"Replaced","content":"Hi","author":"Me","published":true,"reactions":{"👎":9}}`)
+			decode(t, do(t, h, http.MethodGet, "/posts/1/reactions", ""), &got)
+			if !reflect.DeepEqual(got.Reactions, want) {
+				t.Errorf("after an edit reactions = %v, want %v", got.Reactions, want)
+			}
+			var post Post
+			decode(t, do(t, h, http.MethodGet, "/posts/1", ""), &post)
+			if !reflect.DeepEqual(post.Reactions, want) {
+				t.Errorf("post has reactions %v, want %v", post.Reactions, want)
+			}
+
+			// No reactions is an empty object
+			rec = do(t, h, http.MethodGet, "/posts/2/reactions", "")
+			if body := strings.TrimSpace(rec.Body.String()); body != `{"reactions":{}}` {
+				t.Errorf("got %s, want no reactions", body)
+			}
+
+			for _, emoji := range []string{"", "a", "👍👍", "🦄"} {
+				expectStatus(t, do(t, h, http.MethodPost, "/posts/1/reactions", `{"emoji":"`+emoji+`"}`), http.StatusUnprocessableEntity)
+			}
+			expectStatus(t, do(t, h, http.MethodPost, "/posts/99/reactions", `{"emoji":"👍"}`), http.StatusNotFound)
+			expectStatus(t, do(t, h, http.MethodGet, "/posts/99/reactions", ""), http.StatusNotFound)
+		})
+	}
+}
+
+func TestViews(t *testing.T) {
+	a := newTestAPI(config{})
+	a.views = newViewCounter(a.store)
+	h := newRouter(a)
+
+	do(t, h, http.MethodGet, "/posts/1", "")
+	do(t, h, http.MethodGet, "/posts/1", "")
+	do(t, h, http.MethodGet, "/posts/slug/welcome-to-go", "")
+	do(t, h, http.MethodGet, "/posts", "") // lists aren't views
+
+	// Closing writes out what's still pending, requests still running after it count nothing
+	a.views.close()
+	do(t, h, http.MethodGet, "/posts/2", "")
+	a.views.close()
+	for id, want := range map[int]int{1: 3, 2: 0} {
+		if post, _ := a.store.Get(context.Background(), id); post.Views != want {
+			t.Errorf("post %d has %d views, want %d", id, post.Views, want)
+		}
+	}
+}
+
+func TestTrending(t *testing.T) {
+	a := newTestAPI(config{})
+	h := newRouter(a)
+
+	rec := do(t, h, http.MethodGet, "/posts/trending", "")
+	expectStatus(t, rec, http.StatusOK)
+	if got := strings.TrimSpace(rec.Body.String()); got != "[]" {
+		t.Fatalf("body = %s, want [] before any views", got)
+	}
+
+	a.store.AddViews(context.Background(), 1, 2)
+	a.store.AddViews(context.Background(), 2, 5)
+	var posts []Post
+	decode(t, do(t, h, http.MethodGet, "/posts/trending", ""), &posts)
+	if len(posts) != 2 || posts[0].ID != 2 || posts[1].ID != 1 || posts[0].Content != "" {
+		t.Errorf("got %+v, want post 2 then post 1 without content", posts)
+	}
+
+	decode(t, do(t, h, http.MethodGet, "/posts/trending?limit=1", ""), &posts)
+	if len(posts) != 1 || posts[0].ID != 2 {
+		t.Errorf("got %+v, want only post 2", posts)
+	}
+}
+
+func TestRandomPost(t *testing.T) {
+	h := setup(t)
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"Draft","content":"Not yet","author":"Gopher","tags":["go"]}`), http.StatusCreated)
+
+	// Every published post comes up sooner or later, the draft never does
+	seen := map[int]bool{}
+	for range 100 {
+		rec := do(t, h, http.MethodGet, "/posts/random", "")
+		expectStatus(t, rec, http.StatusOK)
+		var post Post
+		decode(t, rec, &post)
+		seen[post.ID] = true
+	}
+	if !seen[1] || !seen[2] || len(seen) != 2 {
+		t.Errorf("picked posts %v, want both published posts and nothing else", seen)
+	}
+
+	// Filters narrow down what it picks from
+	for target, want := range map[string]int{"/posts/random?tag=intro": 1, "/posts/random?author=developer": 2} {
+		var post Post
+		decode(t, do(t, h, http.MethodGet, target, ""), &post)
+		if post.ID != want {
+			t.Errorf("%s picked post %d, want %d", target, post.ID, want)
+		}
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/random?tag=go&author=nobody", ""), http.StatusNotFound)
+}
+
+// postIDs lists the IDs of posts in order
+func postIDs(posts []Post) []int {
+	ids := []int{}
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func TestOnThisDay(t *testing.T) {
+	a := newTestAPI(config{})
+	deleted := time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC)
+	err := a.store.(*MemStore).Seed(context.Background(), []Post{
+		{ID: 1, Title: "Pi day", Content: "3.14", Author: "Gopher", Published: true, CreatedAt: time.Date(2019, 3, 14, 9, 0, 0, 0, time.UTC)},
+		{ID: 2, Title: "Pi day again", Content: "3.1415", Author: "Gopher", Published: true, CreatedAt: time.Date(2023, 3, 14, 23, 0, 0, 0, time.UTC)},
+		{ID: 3, Title: "Day after", Content: "Not today", Author: "Gopher", Published: true, CreatedAt: time.Date(2023, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{ID: 4, Title: "Draft", Content: "Hidden", Author: "Gopher", CreatedAt: time.Date(2020, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{ID: 5, Title: "Trashed", Content: "Hidden", Author: "Gopher", Published: true, Deleted: true, DeletedAt: &deleted, CreatedAt: time.Date(2021, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{ID: 6, Title: "Leap", Content: "Once in four years", Author: "Gopher", Published: true, CreatedAt: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newRouter(a)
+
+	// Any year, most recent first, list style with excerpts instead of content
+	var posts []Post
+	decode(t, do(t, h, http.MethodGet, "/posts/on-this-day?date=03-14", ""), &posts)
+	if ids := postIDs(posts); !slices.Equal(ids, []int{2, 1}) {
+		t.Errorf("got posts %v, want 2 and 1", ids)
+	}
+	if len(posts) > 0 && (posts[0].Content != "" || posts[0].Excerpt == "") {
+		t.Errorf("got %+v, want an excerpt instead of the content", posts[0])
+	}
+	decode(t, do(t, h, http.MethodGet, "/posts/on-this-day?date=02-29", ""), &posts)
+	if ids := postIDs(posts); !slices.Equal(ids, []int{6}) {
+		t.Errorf("got posts %v on 02-29, want 6", ids)
+	}
+
+	// Nothing that day is an empty array, without a date it's today
+	rec := do(t, h, http.MethodGet, "/posts/on-this-day?date=12-25", "")
+	expectStatus(t, rec, http.StatusOK)
+	if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+		t.Errorf("got %s, want []", body)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"Today","content":"Now","author":"Gopher","published":true}`), http.StatusCreated)
+	decode(t, do(t, h, http.MethodGet, "/posts/on-this-day", ""), &posts)
+	if len(posts) == 0 || posts[0].Title != "Today" {
+		t.Errorf("got %+v today, want the new post first", posts)
+	}
+
+	for _, date := range []string{"13-01", "02-30", "3-14", "2024-03-14"} {
+		expectStatus(t, do(t, h, http.MethodGet, "/posts/on-this-day?date="+date, ""), http.StatusBadRequest)
+	}
+}
+
+func TestPostHTML(t *testing.T) {
+	h := setup(t)
+	content := "# Hello\n\nSome **bold** text <script>alert(1)</script> and a [link](javascript:alert(2)).\n\n<img src=x onerror=alert(3)>"
+	rec := do(t, h, http.MethodPost, "/posts", fmt.Sprintf(`{"title":"Markdown","content":%q,"author":"Me","published":true}`, content))
+	expectStatus(t, rec, http.StatusCreated)
+
+	rec = do(t, h, http.MethodGet, "/posts/3/html", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"<h1", "<strong>bold</strong>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body %q doesn't contain %q", body, want)
+		}
+	}
+	for _, unsafe := range []string{"<script", "javascript:", "onerror"} {
+		if strings.Contains(body, unsafe) {
+			t.Errorf("body %q contains %q", body, unsafe)
+		}
+	}
+
+	// The JSON keeps the Markdown as written
+	var post Post
+	decode(t, do(t, h, http.MethodGet, "/posts/3", ""), &post)
+	if post.Content != content {
+		t.Errorf("content = %q, want the raw Markdown", post.Content)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	payloads := []string{
+		`<script>alert(1)</script>`,
+		`<img src=x onerror=alert(1)>`,
+		`<a href="javascript:alert(1)">click</a>`,
+		`<svg onload=alert(1)>`,
+		`"><script>alert(1)</script>`,
+	}
+
+	for _, mode := range []string{"escape", "basic"} {
+		t.Run(mode, func(t *testing.T) {
+			a := newTestAPI(config{})
+			var err error
+			if a.sanitize, err = newSanitizer(mode); err != nil {
+				t.Fatal(err)
+			}
+			h := newRouter(a)
+
+			for _, payload := range payloads {
+				body, _ := json.Marshal(map[string]string{"title": "Hi " + payload, "content": "Text " + payload, "author": "Me " + payload})
+				rec := do(t, h, http.MethodPost, "/posts", string(body))
+				expectStatus(t, rec, http.StatusCreated)
+				var post Post
+				decode(t, rec, &post)
+
+				// What's stored is what a later GET returns
+				stored, _ := a.store.Get(context.Background(), post.ID)
+				for _, field := range []string{stored.Title, stored.Content, stored.Author} {
+					if unsafeHTML(field) {
+						t.Errorf("stored %q for payload %q", field, payload)
+					}
+				}
+			}
+
+			// Updates are cleaned too
+			do(t, h, http.MethodPatch, "/posts/1", `{"content":"<script>alert(1)</script>"}`)
+			do(t, h, http.MethodPut, "/posts/2", `{"title":"T","content":"<b onclick=alert(1)>hi</b>","author":"A"}`)
+			for _, id := range []int{1, 2} {
+				if post, _ := a.store.Get(context.Background(), id); unsafeHTML(post.Content) {
+					t.Errorf("update stored %q", post.Content)
+				}
+			}
+		})
+	}
+}
+
+// unsafeHTML reports whether s, put in a page as is, has tags or attributes that run script
+func unsafeHTML(s string) bool {
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.Data == "script" || tok.Data == "svg" || tok.Data == "iframe" {
+				return true
+			}
+			for _, attr := range tok.Attr {
+				if strings.HasPrefix(attr.Key, "on") || strings.HasPrefix(strings.TrimSpace(strings.ToLower(attr.Val)), "javascript:") {
+					return true
+				}
+			}
+		}
+	}
+}
+
+func TestSanitizeModes(t *testing.T) {
+	escape, _ := newSanitizer("escape")
+	if got := escape("Tom & Jerry <b>"); got != "Tom &amp; Jerry &lt;b&gt;" {
+		t.Errorf("escape = %q", got)
+	}
+	// Sending escaped text back doesn't escape it again
+	if got := escape("Tom &amp; Jerry"); got != "Tom &amp; Jerry" {
+		t.Errorf("escape twice = %q", got)
+	}
+
+	basic, _ := newSanitizer("basic")
+	if got := basic(`<b>bold</b><script>x</script>`); got != "<b>bold</b>" {
+		t.Errorf("basic = %q, want the formatting kept", got)
+	}
+	// Nothing left once the markup is gone means the field is empty
+	h := newRouter(&api{store: NewMemStore(), comments: NewMemCommentStore(), sanitize: basic})
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"T","content":"<script>x</script>","author":"A"}`), http.StatusUnprocessableEntity)
+
+	if _, err := newSanitizer("nope"); err == nil {
+		t.Error("want an error for an unknown mode")
+	}
+}
+
+func TestSanitizedText(t *testing.T) {
+	cfg := defaultConfig(t)
+	cfg.authors = []string{"O'Brien"}
+	h := setupWith(t, cfg)
+
+	title := `Tom & Jerry's "best"`
+	content := "> quote\n\nUse `a < b` & more"
+	body, _ := json.Marshal(map[string]any{"title": title, "content": content, "author": "o'brien", "published": true})
+	rec := do(t, h, http.MethodPost, "/posts", string(body))
+	expectStatus(t, rec, http.StatusCreated)
+	var post Post
+	decode(t, rec, &post)
+
+	// Stored escaped, but the slug and the author check see the text as it was sent
+	if unsafeHTML(post.Title) || post.Slug != "tom-jerrys-best" || post.Author != "O&#39;Brien" {
+		t.Errorf("created %+v, want the escaped title with a slug from the text", post)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/slug/tom-jerrys-best", ""), http.StatusOK)
+
+	// Markdown is rendered from the text, not from the entities
+	rec = do(t, h, http.MethodGet, fmt.Sprintf("/posts/%d/html", post.ID), "")
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Body.String(); !strings.Contains(got, "<blockquote>") || !strings.Contains(got, "<code>a &lt; b</code>") || strings.Contains(got, "&amp;lt;") {
+		t.Errorf("html = %q, want a blockquote and the code escaped once", got)
+	}
+
+	// Searches and highlights find the quote
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts?q=jerry's&highlight=true", ""), &list)
+	if list.Total != 1 || list.Data[0].Title != `Tom &amp; <mark>Jerry&#39;s</mark> &#34;best&#34;` {
+		t.Errorf("got %+v, want the post with its match marked", list.Data)
+	}
+	decode(t, do(t, h, http.MethodGet, "/authors/O'Brien/posts", ""), &list)
+	if list.Total != 1 {
+		t.Errorf("got %d posts by O'Brien, want 1", list.Total)
+	}
+
+	// The feed and the CSV have the text, escaped once by their own encoding
+	var feed rss
+	if err := xml.NewDecoder(do(t, h, http.MethodGet, "/feed.xml", "").Body).Decode(&feed); err != nil {
+		t.Fatal(err)
+	}
+	if item := feed.Channel.Items[0]; item.Title != title || item.Author != "O'Brien" {
+		t.Errorf("feed item is %q by %q, want %q", item.Title, item.Author, title)
+	}
+	rows, err := csv.NewReader(do(t, h, http.MethodGet, "/posts.csv", "").Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := rows[len(rows)-1]; last[1] != title || last[2] != content {
+		t.Errorf("CSV row = %q, want the title and content as sent", last)
+	}
+}
+
+func TestAllowedAuthors(t *testing.T) {
+	h := setupWith(t, config{authors: []string{"Gopher", "Developer"}})
+
+	// Any casing matches, the list's spelling is what's stored
+	rec := do(t, h, http.MethodPost, "/posts", `{"title":"Hi","content":"There","author":"gOPHER"}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var post Post
+	decode(t, rec, &post)
+	if post.Author != "Gopher" {
+		t.Errorf("author = %q, want Gopher", post.Author)
+	}
+
+	rec = do(t, h, http.MethodPost, "/posts", `{"title":"Hi","content":"There","author":"Mallory"}`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if want := []FieldError{{"author", "must be one of Gopher, Developer"}}; !reflect.DeepEqual(apiErr.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", apiErr.Errors, want)
+	}
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/batch", `[{"title":"A","content":"B","author":"Gopher"},{"title":"C","content":"D","author":"Mallory"}]`), http.StatusUnprocessableEntity)
+	expectStatus(t, do(t, h, http.MethodPut, "/posts/1", `{"title":"A","content":"B","author":"Mallory"}`), http.StatusUnprocessableEntity)
+	expectStatus(t, do(t, h, http.MethodPatch, "/posts/1", `{"author":"Mallory"}`), http.StatusUnprocessableEntity)
+
+	rec = do(t, h, http.MethodPatch, "/posts/1", `{"author":"developer"}`)
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &post)
+	if post.Author != "Developer" {
+		t.Errorf("author = %q, want Developer", post.Author)
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	h := setupWith(t, config{maxTitle: 5, authors: []string{"Gopher"}})
+
+	// Every bad field is reported in one response
+	rec := do(t, h, http.MethodPost, "/posts", `{"id":-1,"title":"Far too long","author":"Mallory"}`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	want := []FieldError{
+		{"title", "must be at most 5 characters"},
+		{"content", "required"},
+		{"author", "must be one of Gopher"},
+		{"id", "can't be negative"},
+	}
+	if apiErr.Code != codeInvalidFields || !reflect.DeepEqual(apiErr.Errors, want) {
+		t.Errorf("got %+v, want errors %+v", apiErr, want)
+	}
+
+	rec = do(t, h, http.MethodPatch, "/posts/1", `{"title":"","content":""}`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	decode(t, rec, &apiErr)
+	want = []FieldError{{"title", "can't be empty"}, {"content", "can't be empty"}}
+	if !reflect.DeepEqual(apiErr.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", apiErr.Errors, want)
+	}
+}
+
+func TestMaxLengths(t *testing.T) {
+	h := setupWith(t, config{maxTitle: 5, maxContent: 10})
+
+	// Limits count characters, "héllo" is 5 of them in 6 bytes
+	expectStatus(t, do(t, h, http.MethodPost, "/posts", `{"title":"héllo","content":"0123456789","author":"Me"}`), http.StatusCreated)
+
+	for _, c := range []struct{ method, target, body, field string }{
+		{http.MethodPost, "/posts", `{"title":"héllo!","content":"Hi","author":"Me"}`, "title"},
+		{http.MethodPost, "/posts", `{"title":"Hi","content":"01234567890","author":"Me"}`, "content"},
+		{http.MethodPost, "/posts/batch", `[{"title":"Too long","content":"Hi","author":"Me"}]`, "0.title"},
+		{http.MethodPut, "/posts/1", `{"title":"Too long","content":"Hi","author":"Me"}`, "title"},
+		{http.MethodPatch, "/posts/1", `{"content":"Way too long"}`, "content"},
+	} {
+		rec := do(t, h, c.method, c.target, c.body)
+		expectStatus(t, rec, http.StatusUnprocessableEntity)
+		if !strings.Contains(rec.Body.String(), `"field":"`+c.field+`","message":"must be at most`) {
+			t.Errorf("%s %s: body %s doesn't name the field and limit", c.method, c.target, rec.Body.String())
+		}
+	}
+}
+
+func TestAuthors(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPost, "/posts", `{"title":"More","content":"Go","author":"Developer","published":true}`)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Draft","content":"Go","author":"Ghost"}`)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Gone","content":"Go","author":"Ann","published":true}`)
+	do(t, h, http.MethodDelete, "/posts/5", "")
+
+	rec := do(t, h, http.MethodGet, "/authors", "")
+	expectStatus(t, rec, http.StatusOK)
+	var authors []authorCount
+	decode(t, rec, &authors)
+	want := []authorCount{{"Developer", 2}, {"Gopher", 1}}
+	if !reflect.DeepEqual(authors, want) {
+		t.Errorf("got %+v, want %+v", authors, want)
+	}
+}
+
+func TestAuthorPosts(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPost, "/posts", `{"title":"More","content":"Go","author":"Jane Doe","published":true}`)
+	do(t, h, http.MethodPost, "/posts", `{"title":"Again","content":"Go","author":"Jane Doe","published":true}`)
+
+	rec := do(t, h, http.MethodGet, "/authors/jane%20doe/posts?sort=title&limit=1", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list PostList
+	decode(t, rec, &list)
+	if list.Total != 2 || len(list.Data) != 1 || list.Data[0].Title != "Again" {
+		t.Errorf("got %+v, want Again first of Jane Doe's 2 posts", list)
+	}
+	if link := rec.Header().Get("Link"); !strings.Contains(link, "</authors/jane%20doe/posts?") {
+		t.Errorf("Link = %q, want it to point back to the author's posts", link)
+	}
+
+	// Without an allow-list an unknown author just has no posts
+	decode(t, do(t, h, http.MethodGet, "/authors/nobody/posts", ""), &list)
+	if list.Total != 0 || len(list.Data) != 0 {
+		t.Errorf("got %+v, want no posts", list)
+	}
+
+	h = setupWith(t, config{authors: []string{"Gopher", "Nobody"}})
+	expectStatus(t, do(t, h, http.MethodGet, "/authors/nobody/posts", ""), http.StatusOK)
+	expectStatus(t, do(t, h, http.MethodGet, "/authors/stranger/posts", ""), http.StatusNotFound)
+}
+
+func TestSparseFields(t *testing.T) {
+	h := setup(t)
+
+	// The id comes along even when not asked for, unknown names are ignored
+	rec := do(t, h, http.MethodGet, "/posts?fields=title,author,nope", "")
+	expectStatus(t, rec, http.StatusOK)
+	var list struct {
+		Data  []map[string]any `json:"data"`
+		Total int              `json:"total"`
+	}
+	decode(t, rec, &list)
+	want := map[string]any{"id": 1.0, "title": "Welcome to Go", "author": "Gopher"}
+	if list.Total != 2 || !reflect.DeepEqual(list.Data[0], want) {
+		t.Errorf("got %+v, want %v first", list, want)
+	}
+
+	rec = do(t, h, http.MethodGet, "/posts/2?fields=content", "")
+	expectStatus(t, rec, http.StatusOK)
+	var post map[string]any
+	decode(t, rec, &post)
+	if want := map[string]any{"id": 2.0, "content": "Fast, simple, and reliable."}; !reflect.DeepEqual(post, want) {
+		t.Errorf("got %v, want %v", post, want)
+	}
+
+	h = setupWith(t, config{strictFields: true})
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?fields=title,nope", ""), http.StatusBadRequest)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1?fields=title", ""), http.StatusOK)
+}
+
+func TestDateRange(t *testing.T) {
+	h := setup(t)
+	day := func(days int) string { return time.Now().UTC().AddDate(0, 0, days).Format(time.DateOnly) }
+
+	// The samples were created 7 and 2 days ago
+	for _, c := range []struct {
+		query string
+		want  []int
+	}{
+		{"from=" + day(-3), []int{2}},
+		{"to=" + day(-7), []int{1}}, // a plain date includes the whole day
+		{"from=" + day(-7) + "&to=" + day(-2), []int{1, 2}},
+		{"from=" + day(-1), []int{}},
+		{"from=" + day(-30) + "&author=gopher", []int{1}},
+	} {
+		var list PostList
+		decode(t, do(t, h, http.MethodGet, "/posts?"+c.query, ""), &list)
+		got := []int{}
+		for _, post := range list.Data {
+			got = append(got, post.ID)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("?%s got posts %v, want %v", c.query, got, c.want)
+		}
+	}
+
+	// RFC 3339 times work too, and the ends are included
+	var post Post
+	decode(t, do(t, h, http.MethodGet, "/posts/1", ""), &post)
+	at := url.QueryEscape(post.CreatedAt.Format(time.RFC3339Nano))
+	var count map[string]int
+	decode(t, do(t, h, http.MethodGet, "/posts/count?from="+at+"&to="+at, ""), &count)
+	if count["count"] != 1 {
+		t.Errorf("count = %d, want 1", count["count"])
+	}
+
+	for _, query := range []string{"from=yesterday", "to=2024-13-01", "from=" + day(0) + "&to=" + day(-1)} {
+		expectStatus(t, do(t, h, http.MethodGet, "/posts?"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestReady(t *testing.T) {
+	a := newTestAPI(config{})
+	h := newRouter(a)
+	expectStatus(t, do(t, h, http.MethodGet, "/ready", ""), http.StatusOK)
+
+	a.shuttingDown.Store(true)
+	expectStatus(t, do(t, h, http.MethodGet, "/ready", ""), http.StatusServiceUnavailable)
+	expectStatus(t, do(t, h, http.MethodGet, "/up", ""), http.StatusOK)
+
+	// A database that went away isn't ready
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = newTestAPI(config{})
+	a.store = store
+	h = newRouter(a)
+	expectStatus(t, do(t, h, http.MethodGet, "/ready", ""), http.StatusOK)
+	store.Close()
+	expectStatus(t, do(t, h, http.MethodGet, "/ready", ""), http.StatusServiceUnavailable)
+}
+
+func TestHealth(t *testing.T) {
+	a := newTestAPI(config{})
+	a.started = time.Now().Add(-time.Hour - 3*time.Minute)
+	h := newRouter(a)
+	do(t, h, http.MethodDelete, "/posts/2", "")
+
+	var got health
+	rec := do(t, h, http.MethodGet, "/health", "")
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &got)
+	if want := (health{Status: "ok", Store: "memory", Posts: 1, Uptime: "1h3m0s"}); got != want {
+		t.Errorf("health = %+v, want %+v", got, want)
+	}
+
+	// A database that went away is degraded, not down
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = newTestAPI(config{})
+	a.store = store
+	h = newRouter(a)
+	decode(t, do(t, h, http.MethodGet, "/health", ""), &got)
+	if got.Status != "ok" || got.Store != "sqlite" || got.Posts != 2 {
+		t.Errorf("health = %+v", got)
+	}
+	store.Close()
+	rec = do(t, h, http.MethodGet, "/health", "")
+	expectStatus(t, rec, http.StatusOK)
+	got = health{}
+	decode(t, rec, &got)
+	if got.Status != "degraded" || got.Error == "" {
+		t.Errorf("health with the database closed = %+v", got)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	h := setupWith(t, config{metrics: true, dev: true, graphql: true, apiKey: "secret", uploadDir: t.TempDir()})
+
+	rec := do(t, h, http.MethodGet, "/openapi.json", "")
+	expectStatus(t, rec, http.StatusOK)
+	var doc openAPIDoc
+	decode(t, rec, &doc)
+
+	// Every route is in the spec and has been written up
+	err := chi.Walk(h.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		op, ok := doc.Paths[routePattern(route)][strings.ToLower(method)]
+		if !ok || op.Summary == "" {
+			t.Errorf("%s %s has no summary in operations", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And the schemas they refer to exist
+	for route, op := range operations {
+		for _, name := range []string{op.response, op.body} {
+			if name = strings.TrimPrefix(name, "[]"); name != "" && schemaTypes[name] == nil {
+				t.Errorf("%s refers to a missing %s schema", route, name)
+			}
+		}
+	}
+
+	// The schema follows the Post struct
+	post := doc.Components["schemas"]["Post"]
+	for _, field := range []string{"id", "title", "tags", "likes", "deleted_at", "created_at"} {
+		if post.Properties[field] == nil {
+			t.Errorf("Post schema has no %q", field)
+		}
+	}
+	if got := post.Properties["deleted_at"]; got.Format != "date-time" || !got.Nullable {
+		t.Errorf("deleted_at = %+v, want a nullable date-time", got)
+	}
+
+	op := doc.Paths["/posts/{id}"]["get"]
+	if len(op.Parameters) != 1 || op.Parameters[0].Name != "id" || op.Responses["200"].Content == nil {
+		t.Errorf("GET /posts/{id} = %+v", op)
+	}
+	if op := doc.Paths["/posts"]["post"]; op.RequestBody == nil || op.Responses["201"].Description == "" {
+		t.Errorf("POST /posts = %+v", op)
+	}
+
+	rec = do(t, h, http.MethodGet, "/docs", "")
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestClonePost(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPost, "/posts/1/like", "")
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/comments", `{"author":"Me","body":"Nice"}`), http.StatusCreated)
+
+	rec := do(t, h, http.MethodPost, "/posts/1/clone", "")
+	expectStatus(t, rec, http.StatusCreated)
+	if got := rec.Header().Get("Location"); got != "http://example.com/posts/3" {
+		t.Errorf("Location = %q, want http://example.com/posts/3", got)
+	}
+	var clone Post
+	decode(t, rec, &clone)
+	if clone.ID != 3 || clone.Title != "Welcome to Go (copy)" || clone.Slug != "welcome-to-go-copy" || clone.Published || clone.Likes != 0 {
+		t.Errorf("got clone %+v", clone)
+	}
+	if clone.Content != "Go is awesome for backend development!" || !reflect.DeepEqual(clone.Tags, []string{"go", "intro"}) {
+		t.Errorf("clone content %q and tags %v weren't copied", clone.Content, clone.Tags)
+	}
+
+	var comments []Comment
+	decode(t, do(t, h, http.MethodGet, "/posts/3/comments?status=all", ""), &comments)
+	if len(comments) != 0 {
+		t.Errorf("clone has comments %+v", comments)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/99/clone", ""), http.StatusNotFound)
+
+	// The suffix can push a title over the limit
+	h = setupWith(t, config{maxTitle: 15})
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/clone", ""), http.StatusUnprocessableEntity)
+}
+
+func TestArchive(t *testing.T) {
+	at := func(date string) time.Time {
+		t, _ := time.Parse(time.DateOnly, date)
+		return t
+	}
+	store := NewMemStore()
+	store.posts = []Post{
+		{ID: 1, Published: true, CreatedAt: at("2024-03-01")},
+		{ID: 2, Published: true, CreatedAt: at("2024-03-31")},
+		{ID: 3, Published: true, CreatedAt: at("2023-12-15")},
+		{ID: 4, Published: true, CreatedAt: at("2024-11-02")},
+		{ID: 5, Published: false, CreatedAt: at("2024-05-01")},
+		{ID: 6, Published: true, Deleted: true, CreatedAt: at("2024-06-01")},
+	}
+	a := newTestAPI(config{})
+	a.store = store
+
+	rec := do(t, newRouter(a), http.MethodGet, "/posts/archive", "")
+	expectStatus(t, rec, http.StatusOK)
+	var months []archiveMonth
+	decode(t, rec, &months)
+	want := []archiveMonth{{2024, 11, 1}, {2024, 3, 2}, {2023, 12, 1}}
+	if !reflect.DeepEqual(months, want) {
+		t.Errorf("got %v, want %v", months, want)
+	}
+}
+
+func TestRelatedPosts(t *testing.T) {
+	h := setup(t)
+	for _, body := range []string{
+		`{"title":"Three","content":"Hi","author":"Me","published":true,"tags":["go","backend"]}`,
+		`{"title":"Four","content":"Hi","author":"Me","published":true,"tags":["Backend","go","intro"]}`,
+		`{"title":"Draft","content":"Hi","author":"Me","tags":["go","backend"]}`,
+		`{"title":"Untagged","content":"Hi","author":"Me","published":true}`,
+	} {
+		expectStatus(t, do(t, h, http.MethodPost, "/posts", body), http.StatusCreated)
+	}
+
+	ids := func(target string) []int {
+		t.Helper()
+		rec := do(t, h, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusOK)
+		var posts []Post
+		decode(t, rec, &posts)
+		got := []int{}
+		for _, post := range posts {
+			got = append(got, post.ID)
+		}
+		return got
+	}
+
+	// Two shared tags beat one, and the newer post wins a tie
+	if got := ids("/posts/2/related"); !reflect.DeepEqual(got, []int{4, 3, 1}) {
+		t.Errorf("related to 2 = %v, want [4 3 1]", got)
+	}
+	if got := ids("/posts/2/related?limit=1"); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("related to 2 with a limit = %v, want [4]", got)
+	}
+	if got := ids("/posts/6/related"); len(got) != 0 {
+		t.Errorf("related to an untagged post = %v, want none", got)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/5/related", ""), http.StatusNotFound)
+}
+
+func TestAppendPost(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	mem := NewMemStore()
+	initializeSampleData(mem)
+
+	for name, store := range map[string]PostStore{"mem": mem, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			a := newTestAPI(config{maxContent: 2000})
+			a.store = store
+			h := newRouter(a)
+
+			// Appends sent together all make it in
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					expectStatus(t, do(t, h, http.MethodPost, "/posts/2/append", `{"content":"Update"}`), http.StatusOK)
+				}()
+			}
+			wg.Wait()
+
+			rec := do(t, h, http.MethodPost, "/posts/2/append", `{"content":"Last"}`)
+			expectStatus(t, rec, http.StatusOK)
+			var post Post
+			decode(t, rec, &post)
+			want := "Fast, simple, and reliable." + strings.Repeat("\nUpdate", 20) + "\nLast"
+			if post.Content != want || post.Version != 22 || !post.UpdatedAt.After(post.CreatedAt) {
+				t.Errorf("got content %q version %d", post.Content, post.Version)
+			}
+
+			expectStatus(t, do(t, h, http.MethodPost, "/posts/2/append", `{"content":"  "}`), http.StatusBadRequest)
+			expectStatus(t, do(t, h, http.MethodPost, "/posts/2/append", `{}`), http.StatusBadRequest)
+			expectStatus(t, do(t, h, http.MethodPost, "/posts/2/append", `{"content":"`+strings.Repeat("x", 2000)+`"}`), http.StatusUnprocessableEntity)
+			expectStatus(t, do(t, h, http.MethodPost, "/posts/99/append", `{"content":"Hi"}`), http.StatusNotFound)
+
+			do(t, h, http.MethodDelete, "/posts/2", "")
+			expectStatus(t, do(t, h, http.MethodPost, "/posts/2/append", `{"content":"Hi"}`), http.StatusNotFound)
+		})
+	}
+}
+
+func TestEmbedComments(t *testing.T) {
+	a := newTestAPI(config{cacheTTL: time.Minute, cacheSize: 10})
+	h := newRouter(a)
+	for i := 0; i < maxEmbeddedComments+2; i++ {
+		approvedComment(t, a, h, 1, `{"author":"Me","body":"Nice"}`)
+	}
+	// Comments waiting for moderation aren't counted
+	do(t, h, http.MethodPost, "/posts/2/comments", `{"author":"Spam","body":"Buy now"}`)
+
+	var list PostList
+	decode(t, do(t, h, http.MethodGet, "/posts?embed=comment_count,comments", ""), &list)
+	first, second := list.Data[0], list.Data[1]
+	if first.CommentCount == nil || *first.CommentCount != maxEmbeddedComments+2 || len(first.Comments) != maxEmbeddedComments {
+		t.Errorf("post 1 has count %v and %d comments", first.CommentCount, len(first.Comments))
+	}
+	if second.CommentCount == nil || *second.CommentCount != 0 || second.Comments != nil {
+		t.Errorf("post 2 has count %v and comments %v", second.CommentCount, second.Comments)
+	}
+
+	// A new comment shows up past the cache, and ?fields= keeps what was embedded
+	approvedComment(t, a, h, 2, `{"author":"Me","body":"Hi"}`)
+	var sparse struct {
+		Data []map[string]any `json:"data"`
+	}
+	decode(t, do(t, h, http.MethodGet, "/posts?embed=comment_count&fields=title", ""), &sparse)
+	if want := map[string]any{"id": 2.0, "title": "Why Choose Go?", "comment_count": 1.0}; !reflect.DeepEqual(sparse.Data[1], want) {
+		t.Errorf("got %v, want %v", sparse.Data[1], want)
+	}
+
+	// Nothing changes without ?embed=
+	rec := do(t, h, http.MethodGet, "/posts", "")
+	if strings.Contains(rec.Body.String(), "comment") {
+		t.Errorf("got comments without asking: %s", rec.Body.String())
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts?embed=likes", ""), http.StatusBadRequest)
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a := newTestAPI(config{apiKey: "secret"})
+	audit, err := newAuditLog(path, "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.audit = audit
+	h := newRouter(a)
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := send(http.MethodPost, "/posts", `{"title":"Audited","content":"Hi","author":"Me"}`)
+	expectStatus(t, rec, http.StatusCreated)
+	var created Post
+	decode(t, rec, &created)
+	target := "/posts/" + strconv.Itoa(created.ID)
+	expectStatus(t, send(http.MethodPatch, target, `{"title":"Audited again"}`), http.StatusOK)
+	expectStatus(t, send(http.MethodDelete, target, ""), http.StatusNoContent)
+	expectStatus(t, send(http.MethodDelete, target+"/permanent", ""), http.StatusNoContent)
+
+	// The log is private, even to read
+	expectStatus(t, do(t, h, http.MethodGet, "/admin/audit", ""), http.StatusUnauthorized)
+
+	rec = send(http.MethodGet, "/admin/audit", "")
+	expectStatus(t, rec, http.StatusOK)
+	var page auditPage
+	decode(t, rec, &page)
+	var actions []string
+	for _, e := range page.Data {
+		actions = append(actions, e.Action)
+		if e.PostID != created.ID || e.Actor != auditActor("secret") || e.IP != "192.0.2.1" || e.Time.IsZero() {
+			t.Errorf("got entry %+v", e)
+		}
+	}
+	want := []string{auditPurged, eventDeleted, eventUpdated, eventCreated}
+	if page.Total != 4 || !reflect.DeepEqual(actions, want) {
+		t.Errorf("got %d entries %v, want %v", page.Total, actions, want)
+	}
+	if strings.Contains(page.Data[0].Actor, "secret") {
+		t.Errorf("actor %q gives the key away", page.Data[0].Actor)
+	}
+
+	rec = send(http.MethodGet, "/admin/audit?limit=2&offset=1", "")
+	decode(t, rec, &page)
+	if len(page.Data) != 2 || page.Data[0].Action != eventDeleted || page.Data[1].Action != eventUpdated {
+		t.Errorf("got page %+v, want the delete and the update", page.Data)
+	}
+
+	// What was written to the file is there after a restart, and IDs carry on.
+	// Requests still running after close are only recorded in memory.
+	if err := audit.close(); err != nil {
+		t.Fatal(err)
+	}
+	send(http.MethodPost, "/posts", `{"title":"Late","content":"Too late","author":"Me"}`)
+	if err := audit.close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := newAuditLog(path, "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.close()
+	reopened.record(httptest.NewRequest(http.MethodPost, "/posts", nil), eventCreated, 9)
+	page = reopened.page(defaultLimit, 0)
+	if page.Total != 5 || page.Data[0].ID != 5 || page.Data[0].PostID != 9 || page.Data[4].Action != eventCreated {
+		t.Errorf("after reopening got %+v", page)
+	}
+
+	// Without an API key there's no way to keep the log private, so no route
+	expectStatus(t, do(t, setup(t), http.MethodGet, "/admin/audit", ""), http.StatusNotFound)
+}
+
+func TestBackupRestore(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	if err := sqlite.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemStore()
+	initializeSampleData(mem)
+
+	for name, store := range map[string]PostStore{"mem": mem, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			a := newTestAPI(config{apiKey: "secret"})
+			a.store = store
+			h := newRouter(a)
+			send := func(method, target, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, target, strings.NewReader(body))
+				req.Header.Set("X-API-Key", "secret")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+
+			expectStatus(t, send(http.MethodPost, "/posts/1/comments", `{"author":"Ann","body":"Nice"}`), http.StatusCreated)
+			expectStatus(t, send(http.MethodPost, "/posts/1/like", ""), http.StatusOK)
+			expectStatus(t, send(http.MethodDelete, "/posts/2", ""), http.StatusNoContent)
+
+			// Backups are private like the audit log
+			expectStatus(t, do(t, h, http.MethodGet, "/admin/backup", ""), http.StatusUnauthorized)
+			rec := send(http.MethodGet, "/admin/backup", "")
+			expectStatus(t, rec, http.StatusOK)
+			if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="blog-backup-`) {
+				t.Errorf("Content-Disposition = %q, want an attachment", got)
+			}
+			saved := rec.Body.String()
+			var b backup
+			decode(t, rec, &b)
+			if len(b.Posts) != 2 || len(b.Comments) != 1 || b.Posts[0].Likes != 1 || !b.Posts[1].Deleted {
+				t.Fatalf("got backup %+v", b)
+			}
+
+			// Change everything, then go back
+			expectStatus(t, send(http.MethodPost, "/posts", `{"title":"Later","content":"Hi","author":"Me"}`), http.StatusCreated)
+			expectStatus(t, send(http.MethodDelete, "/posts/1/permanent", ""), http.StatusNoContent)
+			expectStatus(t, send(http.MethodPost, "/admin/restore", saved), http.StatusNoContent)
+
+			posts := store.List(context.Background())
+			if len(posts) != 2 || posts[0].UUID != b.Posts[0].UUID || posts[0].Likes != 1 || posts[0].Version != b.Posts[0].Version ||
+				!posts[1].Deleted || !posts[0].CreatedAt.Equal(b.Posts[0].CreatedAt) {
+				t.Errorf("restored posts %+v, want %+v", posts, b.Posts)
+			}
+			if comments := a.comments.List(1); len(comments) != 1 || comments[0] != b.Comments[0] {
+				t.Errorf("restored comments %+v, want %+v", comments, b.Comments)
+			}
+
+			// New posts and comments don't reuse restored IDs
+			rec = send(http.MethodPost, "/posts", `{"title":"After","content":"Hi","author":"Me"}`)
+			var created Post
+			decode(t, rec, &created)
+			if created.ID != 3 {
+				t.Errorf("new post got ID %d, want 3", created.ID)
+			}
+
+			// A bad backup is refused whole and leaves the store as it was
+			bad := `{"format":1,"posts":[{"id":1,"uuid":"a","title":"One","content":"Hi","author":"Me"},{"id":1,"uuid":"b","title":"","content":"Hi","author":"Me"}],
+				"comments":[{"id":1,"post_id":9,"author":"Ann","body":"Hi"}]}`
+			rec = send(http.MethodPost, "/admin/restore", bad)
+			expectStatus(t, rec, http.StatusUnprocessableEntity)
+			var apiErr APIError
+			decode(t, rec, &apiErr)
+			var fields []string
+			for _, e := range apiErr.Errors {
+				fields = append(fields, e.Field)
+			}
+			if want := []string{"posts.1.id", "posts.1.title", "comments.0.post_id"}; !reflect.DeepEqual(fields, want) {
+				t.Errorf("got errors on %v, want %v", fields, want)
+			}
+			if len(store.List(context.Background())) != 3 {
+				t.Errorf("a refused restore changed the store: %+v", store.List(context.Background()))
+			}
+			expectStatus(t, send(http.MethodPost, "/admin/restore", `{"format":2,"posts":[],"comments":[]}`), http.StatusUnprocessableEntity)
+		})
+	}
+}
+
+func TestRestoreLimits(t *testing.T) {
+	a := newTestAPI(config{apiKey: "secret", sanitize: "basic", maxBodySize: 100, maxRestore: 1 << 20})
+	h := newRouter(a)
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/restore", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Bigger than -max-body, and sanitized like a new post
+	backup := `{"format":1,"posts":[{"id":1,"uuid":"a","title":"<b>Hi</b><script>x</script>","content":"` +
+		strings.Repeat("word ", 100) + `<img src=x onerror=alert(1)>","author":"<script>alert(1)</script>Me","published":true}],"comments":[]}`
+	expectStatus(t, send(backup), http.StatusNoContent)
+	post, _ := a.store.Get(context.Background(), 1)
+	if post.Title != "<b>Hi</b>" || post.Author != "Me" || strings.Contains(post.Content, "onerror") {
+		t.Errorf("restored post wasn't sanitized: %+v", post)
+	}
+
+	// Markup alone is as good as empty
+	rec := send(`{"format":1,"posts":[{"id":1,"uuid":"a","title":"<script>x</script>","content":"Hi","author":"Me"}],"comments":[]}`)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if len(apiErr.Errors) != 1 || apiErr.Errors[0].Field != "posts.0.title" {
+		t.Errorf("got errors %+v, want one on posts.0.title", apiErr.Errors)
+	}
+
+	// -max-restore has the last word
+	a.cfg.maxRestore = 100
+	rec = send(backup)
+	expectStatus(t, rec, http.StatusRequestEntityTooLarge)
+	decode(t, rec, &apiErr)
+	if apiErr.Code != codeBodyTooLarge {
+		t.Errorf("code = %q, want %q", apiErr.Code, codeBodyTooLarge)
+	}
+}
+
+// upload posts a multipart form with data in the named field
+func upload(t *testing.T, h http.Handler, target, field, filename string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAttachments(t *testing.T) {
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	mem := NewMemStore()
+	initializeSampleData(mem)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 100)...)
+	for name, store := range map[string]PostStore{"mem": mem, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := newTestAPI(config{uploadDir: dir, maxUpload: 1 << 10})
+			a.store = store
+			h := newRouter(a)
+
+			rec := upload(t, h, "/posts/1/attachments", "file", `C:\photos\cat.png`, png)
+			expectStatus(t, rec, http.StatusCreated)
+			var att Attachment
+			decode(t, rec, &att)
+			if att.Filename != "cat.png" || att.ContentType != "image/png" || att.Size != int64(len(png)) || !strings.HasPrefix(att.URL, "/uploads/") {
+				t.Errorf("attachment = %+v", att)
+			}
+
+			var list []Attachment
+			decode(t, do(t, h, http.MethodGet, "/posts/1/attachments", ""), &list)
+			if len(list) != 1 || list[0].ID != att.ID {
+				t.Errorf("attachments = %+v, want just %s", list, att.ID)
+			}
+			var post Post
+			decode(t, do(t, h, http.MethodGet, "/posts/1", ""), &post)
+			if len(post.Attachments) != 1 {
+				t.Errorf("post attachments = %+v", post.Attachments)
+			}
+
+			rec = do(t, h, http.MethodGet, att.URL, "")
+			expectStatus(t, rec, http.StatusOK)
+			if got := rec.Header().Get("Content-Type"); got != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", got)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), png) {
+				t.Error("served file differs from the upload")
+			}
+			expectStatus(t, do(t, h, http.MethodGet, "/uploads/..%2Fblog.db", ""), http.StatusNotFound)
+
+			// The type comes from the bytes, not the name or the part's header
+			rec = upload(t, h, "/posts/1/attachments", "file", "evil.png", []byte("<script>alert(1)</script>"))
+			expectStatus(t, rec, http.StatusUnsupportedMediaType)
+			expectStatus(t, upload(t, h, "/posts/1/attachments", "file", "big.png", append(png, make([]byte, 1<<10)...)), http.StatusRequestEntityTooLarge)
+			expectStatus(t, upload(t, h, "/posts/1/attachments", "photo", "cat.png", png), http.StatusBadRequest)
+			expectStatus(t, do(t, h, http.MethodPost, "/posts/1/attachments", `{"file":"cat.png"}`), http.StatusBadRequest)
+			expectStatus(t, upload(t, h, "/posts/99/attachments", "file", "cat.png", png), http.StatusNotFound)
+
+			// Only the accepted upload is on disk, and a permanent delete takes it too
+			files, _ := os.ReadDir(dir)
+			if len(files) != 1 {
+				t.Errorf("upload dir has %d files, want 1", len(files))
+			}
+			expectStatus(t, do(t, h, http.MethodDelete, "/posts/1/permanent", ""), http.StatusNoContent)
+			files, _ = os.ReadDir(dir)
+			if len(files) != 0 {
+				t.Errorf("upload dir has %d files after the post was deleted", len(files))
+			}
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	write := func(data string) string {
+		path := filepath.Join(t.TempDir(), "seed.json")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	seed := write(`[
+		{"title":"Seeded","content":"One","author":"Me","published":true,"created_at":"2024-03-01T10:00:00Z"},
+		{"id":7,"title":"Also seeded","content":"Two","author":"Me","tags":["Demo"]}
+	]`)
+
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	mem := NewMemStore()
+	initializeSampleData(mem)
+
+	for name, store := range map[string]PostStore{"mem": mem, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			a := newTestAPI(config{dev: true})
+			a.store = store
+			if err := a.seedStore(seed); err != nil {
+				t.Fatal(err)
+			}
+			h := newRouter(a)
+
+			check := func() {
+				t.Helper()
+				posts := store.List(context.Background())
+				if len(posts) != 2 || posts[0].ID != 1 || posts[0].Title != "Seeded" || posts[1].ID != 7 || posts[1].Tags[0] != "Demo" {
+					t.Fatalf("got posts %+v", posts)
+				}
+				if want := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC); !posts[0].CreatedAt.Equal(want) || !posts[0].UpdatedAt.Equal(want) {
+					t.Errorf("seeded post created %v and updated %v, want %v", posts[0].CreatedAt, posts[0].UpdatedAt, want)
+				}
+			}
+			check()
+
+			// Resetting goes back to the seed, not the sample data
+			do(t, h, http.MethodDelete, "/posts/1/permanent", "")
+			expectStatus(t, do(t, h, http.MethodPost, "/admin/reset", ""), http.StatusNoContent)
+			check()
+		})
+	}
+
+	// Bad records are reported with their index and nothing is seeded
+	for data, want := range map[string]string{
+		`{"title":"Not an array"}`:                                                                              "cannot unmarshal",
+		`[{"title":"Ok","content":"Hi","author":"Me"},{"titel":"Typo"}]`:                                        `post 1: json: unknown field "titel"`,
+		`[{"title":"Ok","content":"Hi","author":"Me"},{"title":"","content":"Hi","author":"Me"}]`:               "post 1 ",
+		`[{"id":3,"title":"A","content":"Hi","author":"Me"},{"id":3,"title":"B","content":"Hi","author":"Me"}]`: "post 1: a post with this ID already exists",
+	} {
+		a := newTestAPI(config{})
+		err := a.seedStore(write(data))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("seeding %s: got error %v, want %q", data, err, want)
+		}
+		if len(a.store.List(context.Background())) != 2 || a.seed != nil {
+			t.Errorf("seeding %s changed the store", data)
+		}
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	h := setup(t)
+
+	// Publishing doesn't touch the text, so it leaves no revision
+	do(t, h, http.MethodPost, "/posts/1/unpublish", "")
+	rec := do(t, h, http.MethodPut, "/posts/1", `{"title":"Hello Go","content":"Rewritten","author":"Gopher"}`)
+	expectStatus(t, rec, http.StatusOK)
+	do(t, h, http.MethodPatch, "/posts/1", `{"content":"Again"}`)
+
+	// Post 1 is a draft now, its history is as hidden as it is
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/1/revisions", ""), http.StatusNotFound)
+	rec = do(t, h, http.MethodGet, "/posts/1/revisions?status=all", "")
+	expectStatus(t, rec, http.StatusOK)
+	var revs []Revision
+	decode(t, rec, &revs)
+	if len(revs) != 2 || revs[0].Number != 1 || revs[0].Title != "Welcome to Go" || revs[1].Content != "Rewritten" {
+		t.Fatalf("got revisions %+v", revs)
+	}
+
+	rec = do(t, h, http.MethodPost, "/posts/1/revisions/1/restore", "")
+	expectStatus(t, rec, http.StatusOK)
+	var post Post
+	decode(t, rec, &post)
+	if post.Title != "Welcome to Go" || post.Content != "Go is awesome for backend development!" || post.Slug != "welcome-to-go" {
+		t.Errorf("restored post = %+v", post)
+	}
+
+	// Restoring is an edit too, so what it replaced is kept
+	rec = do(t, h, http.MethodGet, "/posts/1/revisions?status=all", "")
+	decode(t, rec, &revs)
+	if len(revs) != 3 || revs[2].Number != 3 || revs[2].Content != "Again" {
+		t.Errorf("got revisions %+v after restoring", revs)
+	}
+
+	expectStatus(t, do(t, h, http.MethodPost, "/posts/1/revisions/9/restore", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/99/revisions", ""), http.StatusNotFound)
+}
+
+func TestDraftRevisions(t *testing.T) {
+	h := setupWith(t, config{apiKey: "secret"})
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-API-Key", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	expectStatus(t, send(http.MethodPost, "/posts", `{"title":"Draft","content":"Secret plans","author":"Me"}`), http.StatusCreated)
+	expectStatus(t, send(http.MethodPatch, "/posts/3", `{"content":"Other plans"}`), http.StatusOK)
+
+	// Without the key a draft's history and everything hanging off it is a 404, like the draft
+	for _, target := range []string{"/posts/3/revisions", "/posts/3/comments", "/posts/3/reactions"} {
+		rec := do(t, h, http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusNotFound)
+		if strings.Contains(rec.Body.String(), "plans") {
+			t.Errorf("%s leaked the draft: %s", target, rec.Body.String())
+		}
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/3/revisions?status=all", ""), http.StatusUnauthorized)
+
+	rec := send(http.MethodGet, "/posts/3/revisions?status=all", "")
+	expectStatus(t, rec, http.StatusOK)
+	var revs []Revision
+	decode(t, rec, &revs)
+	if len(revs) != 1 || revs[0].Content != "Secret plans" {
+		t.Errorf("got revisions %+v", revs)
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	h := setup(t)
+	do(t, h, http.MethodPatch, "/posts/2", `{"content":"Fast\nSimple\nReliable"}`)
+	do(t, h, http.MethodPatch, "/posts/2", `{"content":"Fast\nReliable\nFun","author":"Gopher"}`)
+
+	rec := do(t, h, http.MethodGet, "/posts/2/diff?from=2&to=current", "")
+	expectStatus(t, rec, http.StatusOK)
+	var diff revisionDiff
+	decode(t, rec, &diff)
+	want := []diffLine{{" ", "Fast"}, {"-", "Simple"}, {" ", "Reliable"}, {"+", "Fun"}}
+	if !reflect.DeepEqual(diff.Content, want) || diff.TitleChanged || !diff.AuthorChanged {
+		t.Errorf("got diff %+v, want content %v with only the author changed", diff, want)
+	}
+
+	for _, query := range []string{"from=1", "from=1&to=7", "from=x&to=current"} {
+		expectStatus(t, do(t, h, http.MethodGet, "/posts/2/diff?"+query, ""), http.StatusBadRequest)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/99/diff?from=1&to=current", ""), http.StatusNotFound)
+
+	// A draft's diff is as hidden as the draft
+	do(t, h, http.MethodPost, "/posts/2/unpublish", "")
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/2/diff?from=1&to=current", ""), http.StatusNotFound)
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/2/diff?from=1&to=current&status=all", ""), http.StatusOK)
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want []diffLine
+	}{
+		{"", "", []diffLine{}},
+		{"a\nb", "a\nb", []diffLine{{" ", "a"}, {" ", "b"}}},
+		{"a", "b", []diffLine{{"-", "a"}, {"+", "b"}}},
+		{"a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc", []diffLine{
+			{"-", "a"}, {"+", "c"}, {" ", "b"}, {"-", "c"}, {" ", "a"}, {" ", "b"}, {"-", "b"}, {" ", "a"}, {"+", "c"},
+		}},
+	} {
+		if got := diffLines(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("diffLines(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+
+	// Long posts don't take quadratic memory, and past maxDiffEdits the rest
+	// is simply replaced
+	var a, b []string
+	for i := range 20_000 {
+		a = append(a, fmt.Sprintf("old %d", i))
+		b = append(b, fmt.Sprintf("new %d", i))
+	}
+	diff := diffLines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+	if len(diff) != 40_000 || diff[0] != (diffLine{"-", "old 0"}) || diff[20_000] != (diffLine{"+", "new 0"}) {
+		t.Errorf("got %d lines starting %v", len(diff), diff[:2])
+	}
+}
+
+func TestRevisionLimit(t *testing.T) {
+	s := NewMemRevisionStore()
+	for i := 1; i <= maxRevisions+5; i++ {
+		s.Add(Post{ID: 1, Title: strconv.Itoa(i)})
+	}
+
+	revs := s.List(1)
+	if len(revs) != maxRevisions || revs[0].Number != 6 || revs[len(revs)-1].Number != maxRevisions+5 {
+		t.Errorf("kept revisions %d to %d (%d), want 6 to %d", revs[0].Number, revs[len(revs)-1].Number, len(revs), maxRevisions+5)
+	}
+	if _, ok := s.Get(1, 5); ok {
+		t.Error("revision 5 should have been dropped")
+	}
+}
+
+// waitFor polls cond for up to a few seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition not met in time")
+}
+
+// graphqlResponse is what POST /graphql answers with
+type graphqlResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// queryGraphQL posts a GraphQL operation, sending key unless it's empty
+func queryGraphQL(t *testing.T, h http.Handler, key, query string, vars map[string]any) graphqlResponse {
+	t.Helper()
+
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: vars})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusOK)
+	var resp graphqlResponse
+	decode(t, rec, &resp)
+	return resp
+}
+
+// errorCode is the code in the extensions of the response's first error
+func (resp graphqlResponse) errorCode() string {
+	if len(resp.Errors) == 0 {
+		return ""
+	}
+	code, _ := resp.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+// field decodes one field of the response's data into v
+func (resp graphqlResponse) field(t *testing.T, name string, v any) {
+	t.Helper()
+
+	if len(resp.Errors) > 0 {
+		t.Fatalf("errors: %+v", resp.Errors)
+	}
+	if err := json.Unmarshal(resp.Data[name], v); err != nil {
+		t.Fatalf("decoding %s %s: %v", name, resp.Data[name], err)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	h := setupWith(t, config{graphql: true, apiKey: "secret"})
+
+	// Reads are public and only have the fields asked for
+	resp := queryGraphQL(t, h, "", `{ posts(tag: "intro") { id title tags } }`, nil)
+	if raw := string(resp.Data["posts"]); strings.Contains(raw, "content") {
+		t.Errorf("posts = %s, want only the fields asked for", raw)
+	}
+	var posts []Post
+	resp.field(t, "posts", &posts)
+	if len(posts) != 1 || posts[0].ID != 1 || posts[0].Title != "Welcome to Go" {
+		t.Errorf("got %+v, want post 1", posts)
+	}
+	queryGraphQL(t, h, "", `{ posts(limit: 1, offset: 1) { id } }`, nil).field(t, "posts", &posts)
+	if len(posts) != 1 || posts[0].ID != 2 {
+		t.Errorf("got %+v, want post 2", posts)
+	}
+
+	var one struct {
+		Title     string    `json:"title"`
+		CreatedAt time.Time `json:"created_at"`
+		Reactions []struct {
+			Emoji string `json:"emoji"`
+			Count int    `json:"count"`
+		} `json:"reactions"`
+	}
+	queryGraphQL(t, h, "", `query($id: ID!) { post(id: $id) { title created_at reactions { emoji count } } }`, map[string]any{"id": "1"}).field(t, "post", &one)
+	if one.Title != "Welcome to Go" || one.CreatedAt.IsZero() || one.Reactions == nil {
+		t.Errorf("got %+v, want post 1 with its time and an empty list of reactions", one)
+	}
+	var post *Post
+	queryGraphQL(t, h, "", `{ post(id: "99") { id } }`, nil).field(t, "post", &post)
+	if post != nil {
+		t.Errorf("got %+v, want null for a missing post", post)
+	}
+	if code := queryGraphQL(t, h, "", `{ post(id: "abc") { id } }`, nil).errorCode(); code != codeInvalidID {
+		t.Errorf("code = %q, want %q", code, codeInvalidID)
+	}
+
+	// Mutations need the key, and are validated like REST writes
+	create := `mutation($input: PostInput!) { createPost(input: $input) { id slug tags published version } }`
+	input := map[string]any{"title": "Over GraphQL", "content": "Just the fields", "author": "Gopher", "tags": []string{" api "}}
+	if code := queryGraphQL(t, h, "", create, map[string]any{"input": input}).errorCode(); code != codeUnauthorized {
+		t.Errorf("code = %q without the key, want %q", code, codeUnauthorized)
+	}
+	var created Post
+	queryGraphQL(t, h, "secret", create, map[string]any{"input": input}).field(t, "createPost", &created)
+	if created.ID != 3 || created.Slug != "over-graphql" || !reflect.DeepEqual(created.Tags, []string{"api"}) || created.Published {
+		t.Errorf("created %+v, want draft 3 with a slug and trimmed tags", created)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/3?include_drafts=true", ""), http.StatusUnauthorized)
+	invalid := map[string]any{"title": "", "content": "No title", "author": "Gopher"}
+	resp = queryGraphQL(t, h, "secret", create, map[string]any{"input": invalid})
+	if code := resp.errorCode(); code != codeInvalidFields || resp.Errors[0].Extensions["errors"] == nil {
+		t.Errorf("got %+v, want the invalid fields", resp.Errors)
+	}
+
+	update := `mutation($input: PostInput!) { updatePost(id: "3", version: 1, input: $input) { version published content } }`
+	input["content"], input["published"] = "Edited", true
+	var updated Post
+	queryGraphQL(t, h, "secret", update, map[string]any{"input": input}).field(t, "updatePost", &updated)
+	if updated.Version != 2 || !updated.Published || updated.Content != "Edited" {
+		t.Errorf("updated %+v, want the published edit at version 2", updated)
+	}
+	if code := queryGraphQL(t, h, "secret", update, map[string]any{"input": input}).errorCode(); code != codeVersionMismatch {
+		t.Errorf("code = %q updating a stale version, want %q", code, codeVersionMismatch)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/3", ""), http.StatusOK)
+
+	var deleted Post
+	queryGraphQL(t, h, "secret", `mutation { deletePost(id: "3") { deleted } }`, nil).field(t, "deletePost", &deleted)
+	if !deleted.Deleted {
+		t.Errorf("deleted %+v, want it in the trash", deleted)
+	}
+	expectStatus(t, do(t, h, http.MethodGet, "/posts/3", ""), http.StatusNotFound)
+	if code := queryGraphQL(t, h, "secret", `mutation { deletePost(id: "3") { id } }`, nil).errorCode(); code != codeNotFound {
+		t.Errorf("code = %q deleting it again, want %q", code, codeNotFound)
+	}
+
+	// Only there with -graphql, and a body without a query is a 400
+	expectStatus(t, do(t, h, http.MethodPost, "/graphql", `{"query":" "}`), http.StatusBadRequest)
+	expectStatus(t, do(t, setup(t), http.MethodPost, "/graphql", `{"query":"{ posts { id } }"}`), http.StatusNotFound)
+}
+
+// grpcClient serves BlogService for a over an in-memory connection
+func grpcClient(t *testing.T, a *api) blogpb.BlogServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := newGRPCServer(a)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return blogpb.NewBlogServiceClient(conn)
+}
+
+// expectCode fails the test unless err is a gRPC status with the given code
+func expectCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+
+	if got := status.Code(err); got != want {
+		t.Errorf("got code %v (%v), want %v", got, err, want)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	mem := NewMemStore()
+	initializeSampleData(mem)
+	sqlite, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	for name, store := range map[string]PostStore{"mem": mem, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			a := newTestAPI(config{})
+			a.store = store
+			h := newRouter(a)
+			client := grpcClient(t, a)
+			ctx := context.Background()
+
+			created, err := client.CreatePost(ctx, &blogpb.CreatePostRequest{Post: &blogpb.Post{
+				Title: "Over gRPC", Content: "Typed and fast", Author: "Gopher", Tags: []string{"go", " rpc "}, Published: true,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created.Id == 0 || created.Slug != "over-grpc" || created.Version != 1 || !slices.Equal(created.Tags, []string{"go", "rpc"}) {
+				t.Errorf("created %+v, want a new post with a slug and trimmed tags", created)
+			}
+
+			// Both servers share the store
+			expectStatus(t, do(t, h, http.MethodGet, fmt.Sprintf("/posts/%d", created.Id), ""), http.StatusOK)
+			got, err := client.GetPost(ctx, &blogpb.GetPostRequest{Id: "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != "Welcome to Go" || got.CreatedAt.AsTime().IsZero() {
+				t.Errorf("got %+v, want post 1", got)
+			}
+
+			list, err := client.ListPosts(ctx, &blogpb.ListPostsRequest{Tags: []string{"go"}, Sort: "-id", Limit: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if list.Total != 3 || len(list.Posts) != 2 || list.Posts[0].Id != created.Id {
+				t.Errorf("listed %d of %d, want 2 of 3 newest first", len(list.Posts), list.Total)
+			}
+
+			updated, err := client.UpdatePost(ctx, &blogpb.UpdatePostRequest{Id: strconv.Itoa(int(created.Id)), Post: &blogpb.Post{
+				Title: "Over gRPC", Content: "Still typed", Author: "Gopher", Published: true, Version: created.Version,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.Content != "Still typed" || updated.Version != 2 || updated.Slug != "over-grpc" {
+				t.Errorf("updated %+v, want the new content at version 2", updated)
+			}
+			_, err = client.UpdatePost(ctx, &blogpb.UpdatePostRequest{Id: strconv.Itoa(int(created.Id)), Post: &blogpb.Post{
+				Title: "Stale", Content: "Lost", Author: "Gopher", Version: created.Version,
+			}})
+			expectCode(t, err, codes.FailedPrecondition)
+
+			deleted, err := client.DeletePost(ctx, &blogpb.DeletePostRequest{Id: strconv.Itoa(int(created.Id))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !deleted.Post.Deleted || deleted.Post.DeletedAt == nil {
+				t.Errorf("deleted %+v, want it in the trash", deleted.Post)
+			}
+			_, err = client.GetPost(ctx, &blogpb.GetPostRequest{Id: strconv.Itoa(int(created.Id))})
+			expectCode(t, err, codes.NotFound)
+		})
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	a := newTestAPI(config{apiKey: "secret"})
+	client := grpcClient(t, a)
+	ctx := context.Background()
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+
+	// Writes and drafts need the key, published posts don't
+	post := &blogpb.Post{Title: "Draft", Content: "Not yet", Author: "Gopher"}
+	_, err := client.CreatePost(ctx, &blogpb.CreatePostRequest{Post: post})
+	expectCode(t, err, codes.Unauthenticated)
+	_, err = client.CreatePost(metadata.AppendToOutgoingContext(ctx, "x-api-key", "wrong"), &blogpb.CreatePostRequest{Post: post})
+	expectCode(t, err, codes.Unauthenticated)
+	draft, err := client.CreatePost(authed, &blogpb.CreatePostRequest{Post: post})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(int(draft.Id))
+	_, err = client.GetPost(ctx, &blogpb.GetPostRequest{Id: id})
+	expectCode(t, err, codes.NotFound)
+	_, err = client.GetPost(ctx, &blogpb.GetPostRequest{Id: id, IncludeDrafts: true})
+	expectCode(t, err, codes.Unauthenticated)
+	if _, err := client.GetPost(authed, &blogpb.GetPostRequest{Id: id, IncludeDrafts: true}); err != nil {
+		t.Errorf("getting the draft with the key: %v", err)
+	}
+	_, err = client.ListPosts(ctx, &blogpb.ListPostsRequest{Status: statusAll})
+	expectCode(t, err, codes.Unauthenticated)
+	if _, err := client.ListPosts(ctx, &blogpb.ListPostsRequest{}); err != nil {
+		t.Errorf("listing published posts: %v", err)
+	}
+
+	// Invalid fields come back as violations in the details
+	_, err = client.CreatePost(authed, &blogpb.CreatePostRequest{Post: &blogpb.Post{Content: "No title", Author: "Gopher"}})
+	expectCode(t, err, codes.InvalidArgument)
+	var fields []string
+	for _, detail := range status.Convert(err).Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	if !slices.Equal(fields, []string{"title"}) {
+		t.Errorf("violations on %v, want title", fields)
+	}
+
+	_, err = client.CreatePost(authed, &blogpb.CreatePostRequest{Post: &blogpb.Post{Id: 1, Title: "Taken", Content: "ID", Author: "Gopher"}})
+	expectCode(t, err, codes.AlreadyExists)
+	_, err = client.GetPost(ctx, &blogpb.GetPostRequest{Id: "abc"})
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = client.UpdatePost(authed, &blogpb.UpdatePostRequest{Id: "99", Post: post})
+	expectCode(t, err, codes.NotFound)
+	_, err = client.ListPosts(ctx, &blogpb.ListPostsRequest{Sort: "likes"})
+	expectCode(t, err, codes.InvalidArgument)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// listCache remembers encoded getPosts responses for ttl, keeping at most size of them.
+// Every write bumps gen, which makes everything cached before it stale.
+type listCache struct {
+	ttl  time.Duration
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	gen     uint64
+	entries map[string]*list.Element
+	lru     *list.List // most recently used at the front
+}
+
+type cacheEntry struct {
+	key      string
+	body     []byte
+	etag     string
+	link     string
+	modified time.Time
+	gen      uint64
+	expires  time.Time
+}
+
+func newListCache(ttl time.Duration, size int) *listCache {
+	return &listCache{
+		ttl:     ttl,
+		size:    max(size, 1),
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// generation is read before building a response, so a write that lands
+// while it's being built is caught when the response is stored
+func (c *listCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// get returns the cached response for key unless it expired or a write happened since
+func (c *listCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	entry := el.Value.(cacheEntry)
+	if entry.gen != c.gen || !c.now().Before(entry.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return cacheEntry{}, false
+	}
+	c.lru.MoveToFront(el)
+	return entry, true
+}
+
+// put stores a response built at generation entry.gen, evicting the least recently used when full
+func (c *listCache) put(entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry.gen != c.gen {
+		return
+	}
+	entry.expires = c.now().Add(c.ttl)
+
+	if el, ok := c.entries[entry.key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(cacheEntry).key)
+	}
+}
+
+// invalidate makes every cached response stale, they're dropped as they're looked up
+func (c *listCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+}
+
+// invalidateOnWrite bumps the generation after every POST, PUT, PATCH and DELETE,
+// so no handler that changes posts can forget to. It has to run after the
+// handler is done, see requestTimeout in newRouter.
+func (c *listCache) invalidateOnWrite(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if isWrite(r.Method) {
+			c.invalidate()
+		}
+	})
+}
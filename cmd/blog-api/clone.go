@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// clonePost starts a new draft from an existing post. The copy gets its own ID,
+// slug and timestamps, comments, likes and views stay with the original.
+func (a *api) clonePost(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	source, ok := a.findPost(r.Context(), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	clone := Post{
+		Title:   source.Title + " (copy)",
+		Content: source.Content,
+		Author:  source.Author,
+		Tags:    append([]string{}, source.Tags...),
+	}
+
+	// The longer title has to fit the limits like any new post's
+	if errs := a.prepareNewPost(&clone); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+	clone, err = a.store.Create(r.Context(), clone)
+	if err != nil {
+		writeCreateError(w, r, err)
+		return
+	}
+	a.emit(r, eventCreated, clone)
+
+	setETag(w, clone)
+	w.Header().Set("Location", baseURL(r)+a.postPath(clone))
+	writeJSON(w, r, http.StatusCreated, clone)
+}
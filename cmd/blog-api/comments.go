@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Comment struct {
+	ID        int       `json:"id" xml:"id"`
+	PostID    int       `json:"post_id" xml:"post_id"`
+	Author    string    `json:"author" xml:"author"`
+	Body      string    `json:"body" xml:"body"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+
+	// ParentID is the comment this one replies to, 0 for a top-level comment.
+	// Lists stay flat in creation order, so replies come after their parent.
+	ParentID int `json:"parent_id,omitempty" xml:"parent_id,omitempty"`
+
+	// Approved comments are public, new ones wait for a moderator
+	Approved bool `json:"approved" xml:"approved"`
+}
+
+// CommentStore is where comments live, separate from the posts.
+// List and Pending include comments waiting for moderation, handlers filter them.
+type CommentStore interface {
+	List(postID int) []Comment
+	Pending() []Comment
+	Create(c Comment) Comment
+	Approve(postID, id int) (Comment, bool)
+	Delete(postID, id int) bool
+	DeletePost(postID int)
+}
+
+// MemCommentStore keeps comments in memory keyed by post ID, locked the same way as MemStore
+type MemCommentStore struct {
+	mu       sync.RWMutex
+	comments map[int][]Comment
+	nextID   int
+}
+
+func NewMemCommentStore() *MemCommentStore {
+	return &MemCommentStore{comments: map[int][]Comment{}, nextID: 1}
+}
+
+// List returns a copy of the post's comments, never nil
+func (s *MemCommentStore) List(postID int) []Comment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Comment{}, s.comments[postID]...)
+}
+
+// Pending returns the comments waiting for moderation on every post, oldest first
+func (s *MemCommentStore) Pending() []Comment {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pending := []Comment{}
+	for _, comments := range s.comments {
+		for _, c := range comments {
+			if !c.Approved {
+				pending = append(pending, c)
+			}
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+	return pending
+}
+
+// Create assigns the next ID and the creation time, then stores the comment
+func (s *MemCommentStore) Create(c Comment) Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.ID = s.nextID
+	s.nextID++
+	c.CreatedAt = time.Now().UTC()
+	s.comments[c.PostID] = append(s.comments[c.PostID], c)
+	return c
+}
+
+// Approve makes a comment public, false when the post has no such comment
+func (s *MemCommentStore) Approve(postID, id int) (Comment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comments := s.comments[postID]
+	for i := range comments {
+		if comments[i].ID == id {
+			comments[i].Approved = true
+			return comments[i], true
+		}
+	}
+	return Comment{}, false
+}
+
+// Delete removes a comment and every reply under it, so none is left
+// pointing at a parent that's gone
+func (s *MemCommentStore) Delete(postID, id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := map[int]bool{}
+	kept := []Comment{}
+	// Replies come after their parent, so one pass finds the whole thread
+	for _, c := range s.comments[postID] {
+		if c.ID == id || removed[c.ParentID] {
+			removed[c.ID] = true
+			continue
+		}
+		kept = append(kept, c)
+	}
+	s.comments[postID] = kept
+	return removed[id]
+}
+
+// DeletePost drops every comment on a post, so a new post reusing the ID starts without any
+func (s *MemCommentStore) DeletePost(postID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.comments, postID)
+}
+
+// Restore replaces every comment with the given ones, IDs and times included
+func (s *MemCommentStore) Restore(comments []Comment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.comments, s.nextID = map[int][]Comment{}, 1
+	for _, c := range comments {
+		s.comments[c.PostID] = append(s.comments[c.PostID], c)
+		s.nextID = max(s.nextID, c.ID+1)
+	}
+}
+
+// Reset drops every comment
+func (s *MemCommentStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.comments = map[int][]Comment{}
+	s.nextID = 1
+	return nil
+}
+
+// approved keeps the comments a moderator let through, never nil
+func approved(comments []Comment) []Comment {
+	public := []Comment{}
+	for _, c := range comments {
+		if c.Approved {
+			public = append(public, c)
+		}
+	}
+	return public
+}
+
+// replyDepth is how deep a reply to parentID would nest, 1 for a reply to a
+// top-level comment. It's false when the parent isn't among the comments.
+func replyDepth(comments []Comment, parentID int) (int, bool) {
+	byID := make(map[int]Comment, len(comments))
+	for _, c := range comments {
+		byID[c.ID] = c
+	}
+	depth := 0
+	for id := parentID; id != 0; depth++ {
+		c, ok := byID[id]
+		if !ok {
+			return 0, false
+		}
+		id = c.ParentID
+	}
+	return depth, true
+}
+
+func (a *api) getComments(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	if _, ok := a.findVisiblePost(r, id); !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, approved(a.comments.List(id)))
+}
+
+// isNewComment reports whether r is a POST /posts/{id}/comments. Readers comment
+// without the API key, moderation keeps their comments hidden until approved.
+func isNewComment(r *http.Request) bool {
+	rest, ok := strings.CutPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/posts/")
+	if !ok || r.Method != http.MethodPost {
+		return false
+	}
+	id, ok := strings.CutSuffix(rest, "/comments")
+	return ok && id != "" && !strings.Contains(id, "/")
+}
+
+func (a *api) createComment(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	var comment Comment
+
+	// Decode JSON from request body
+	if !decodeJSON(w, r, &comment) {
+		return
+	}
+
+	// Validate required fields
+	if comment.Author == "" || comment.Body == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidFields, "Author and body are required")
+		return
+	}
+
+	// Comments can only go on posts that exist, and that the commenter can see
+	if _, ok := a.findVisiblePost(r, id); !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	// Replies go under a public comment on the same post, no deeper than the limit
+	if comment.ParentID != 0 {
+		depth, ok := replyDepth(approved(a.comments.List(id)), comment.ParentID)
+		if !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidFields, "Parent comment not found on this post")
+			return
+		}
+		if limit := a.cfg.maxCommentDepth; limit > 0 && depth > limit {
+			writeError(w, http.StatusBadRequest, codeInvalidFields, fmt.Sprintf("Replies can only nest %d deep", limit))
+			return
+		}
+	}
+
+	// Only a moderator can approve, see approveComment
+	comment.PostID = id
+	comment.Approved = false
+	comment = a.comments.Create(comment)
+
+	// Return created comment
+	writeJSON(w, r, http.StatusCreated, comment)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionETag is the ETag for a given post version
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// postETag is the ETag of a single post's encoded body. Likes, views and
+// reactions change the body without a new version, and so do ?fields= and
+// XML, so the body's hash is in it. The version leads so If-Match still takes it.
+func postETag(version int, body []byte) string {
+	return `"` + strconv.Itoa(version) + "-" + strings.Trim(bodyETag(body), `"`) + `"`
+}
+
+// setETag tells the client which version of the post it got
+func setETag(w http.ResponseWriter, post Post) {
+	w.Header().Set("ETag", versionETag(post.Version))
+}
+
+// ifMatch returns the version the client expects from the If-Match header,
+// ok is false when there's no usable precondition ("*" or no header)
+func ifMatch(r *http.Request) (version int, ok bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	// Accept the quoted ETag, a post's ETag from a GET and a bare version number
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	tag, _, _ = strings.Cut(tag, "-")
+	version, err = strconv.Atoi(tag)
+	if err != nil {
+		return 0, false, err
+	}
+	return version, true, nil
+}
+
+// checkIfMatch makes sure the client is editing the version it thinks it is,
+// writing the error response and returning false when it isn't.
+// On success the version to update from is stored in post.Version.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current Post, post *Post) bool {
+	expected, ok, err := ifMatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidHeader, "Invalid If-Match header")
+		return false
+	}
+	if ok && expected != current.Version {
+		setETag(w, current)
+		writeError(w, http.StatusPreconditionFailed, codeVersionMismatch, "Post was changed since version "+strconv.Itoa(expected))
+		return false
+	}
+
+	post.Version = current.Version
+	return true
+}
+
+// fresh sets the ETag and Last-Modified validators and answers 304 when the
+// client's cached copy is still current, reporting whether it did.
+// If-None-Match wins over If-Modified-Since when both are sent. HTTP dates
+// have no fractions of a second, so modified is truncated before comparing.
+func fresh(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		modified = modified.UTC().Truncate(time.Second)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if !noneMatch(header, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() || modified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// noneMatch reports whether etag is missing from an If-None-Match list,
+// which compares weakly so a W/ prefix is ignored
+func noneMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return false
+		}
+	}
+	return true
+}
+
+// bodyETag is a strong ETag for a response body, FNV is plenty for telling bodies apart
+func bodyETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return `"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+}
+
+// lastModified is the newest UpdatedAt among posts
+func lastModified(posts []Post) time.Time {
+	var latest time.Time
+	for _, post := range posts {
+		if post.UpdatedAt.After(latest) {
+			latest = post.UpdatedAt
+		}
+	}
+	return latest
+}
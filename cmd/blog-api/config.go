@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// config is everything that can be tuned from flags and env vars
+type config struct {
+	addr string
+
+	// grpcAddr is where BlogService listens, empty leaves gRPC off
+	grpcAddr string
+
+	dataFile    string
+	dbPath      string
+	seed        string
+	corsOrigins []string
+	maxBodySize int64
+	// maxRestore is the largest backup POST /admin/restore takes, it's a whole
+	// blog in one body so -max-body doesn't apply
+	maxRestore int64
+
+	// Images uploaded to posts are kept in uploadDir, up to maxUpload bytes each.
+	// An empty uploadDir turns attachments off.
+	uploadDir string
+	maxUpload int64
+	metrics   bool
+	graphql   bool
+	logFormat string
+
+	// Longest title and content accepted, in characters
+	maxTitle   int
+	maxContent int
+
+	// maxCommentDepth is how deep replies to comments may nest, 0 for no limit
+	maxCommentDepth int
+
+	// uuidIDs makes URLs take posts' UUIDs instead of their numeric IDs
+	uuidIDs bool
+
+	// uniqueTitles makes creating a post titled like another one a 409
+	uniqueTitles bool
+
+	// strictFields makes unknown names in ?fields= a 400 instead of ignoring them
+	strictFields bool
+
+	// unpublishOnDelete makes deleting a published post turn it into a draft, only
+	// drafts go to the trash
+	unpublishOnDelete bool
+
+	// sanitize says how HTML in posts is made safe, escape or basic
+	sanitize string
+
+	// dev turns on endpoints that only make sense while developing
+	dev bool
+
+	// Post lists are cached for cacheTTL, up to cacheSize of them
+	cacheTTL  time.Duration
+	cacheSize int
+
+	// webhookURLs get a POST for every post created, updated or deleted
+	webhookURLs []string
+
+	// apiKey guards every write, when empty anyone can write
+	apiKey string
+
+	// auditLog is the file the audit log is appended to, empty keeps it in memory only
+	auditLog string
+
+	// accessLog is the file request lines go to, rotated past accessLogSize bytes
+	// with accessLogBackups old files kept. Empty logs them with everything else.
+	accessLog        string
+	accessLogSize    int64
+	accessLogBackups int
+
+	// authors are the only names posts may be written under, empty allows anyone
+	authors []string
+
+	// requestTimeout is how long a handler may run before the client gets a 503, 0 for no limit
+	requestTimeout time.Duration
+
+	// Server timeouts, see http.Server. The event stream isn't bound by writeTimeout.
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+
+	// Requests per second and burst allowed per client IP, a rate of 0 turns limiting off
+	rateLimit  float64
+	rateBurst  int
+	trustProxy bool
+}
+
+// loadConfig reads the config from the command line, exiting on a bad flag
+func loadConfig() config {
+	cfg, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// parseConfig reads the config from args, env vars standing in for missing
+// flags. Tests parse no args for the config the server starts with by default.
+func parseConfig(fs *flag.FlagSet, args []string) (config, error) {
+	var cfg config
+
+	// PORT is what most hosting platforms set, -addr wins over it
+	defaultAddr := ":8000"
+	if port := os.Getenv("PORT"); port != "" {
+		defaultAddr = ":" + port
+	}
+	fs.StringVar(&cfg.addr, "addr", defaultAddr, "address to listen on (env PORT)")
+	fs.StringVar(&cfg.grpcAddr, "grpc-addr", os.Getenv("GRPC_ADDR"), "address the gRPC BlogService listens on, empty turns it off (env GRPC_ADDR)")
+	fs.StringVar(&cfg.dataFile, "data", "./posts.json", "JSON file posts are loaded from and saved to")
+	fs.StringVar(&cfg.seed, "seed", "", "JSON file of posts that replace the stored ones at startup, and on POST /admin/reset")
+	fs.StringVar(&cfg.dbPath, "db", os.Getenv("BLOG_DB"), "SQLite database to keep posts in instead of the JSON file (env BLOG_DB)")
+	corsOrigins := fs.String("cors-origins", envOr("CORS_ORIGINS", "*"), "comma-separated origins allowed to call the API (env CORS_ORIGINS)")
+	fs.Int64Var(&cfg.maxBodySize, "max-body", 1<<20, "largest request body accepted, in bytes")
+	fs.Int64Var(&cfg.maxRestore, "max-restore", 64<<20, "largest backup POST /admin/restore accepts, in bytes, 0 for no limit")
+	fs.StringVar(&cfg.uploadDir, "uploads", "./uploads", "directory images attached to posts are stored in, empty turns attachments off")
+	fs.Int64Var(&cfg.maxUpload, "max-upload", 5<<20, "largest image that can be attached to a post, in bytes")
+	fs.IntVar(&cfg.maxTitle, "max-title", 200, "longest post title accepted, in characters, 0 for no limit")
+	fs.IntVar(&cfg.maxContent, "max-content", 100_000, "longest post content accepted, in characters, 0 for no limit")
+	fs.IntVar(&cfg.maxCommentDepth, "max-comment-depth", 5, "how deep replies to comments may nest, 0 for no limit")
+	fs.BoolVar(&cfg.uuidIDs, "uuid-ids", false, "look posts up by UUID instead of numeric ID in URLs and ?ids=")
+	fs.BoolVar(&cfg.uniqueTitles, "unique-titles", false, "refuse new posts titled like an existing one, ignoring case")
+	fs.BoolVar(&cfg.strictFields, "strict-fields", false, "reject unknown names in ?fields= instead of ignoring them")
+	fs.BoolVar(&cfg.unpublishOnDelete, "unpublish-on-delete", false, "deleting a published post unpublishes it instead, answering with the draft")
+	fs.BoolVar(&cfg.metrics, "metrics", true, "serve Prometheus metrics on /metrics")
+	fs.BoolVar(&cfg.graphql, "graphql", false, "serve a GraphQL endpoint on POST /graphql")
+	fs.DurationVar(&cfg.requestTimeout, "request-timeout", 8*time.Second, "longest a request may take before a 503, 0 for no limit, event streams excepted")
+	fs.DurationVar(&cfg.readTimeout, "read-timeout", 5*time.Second, "longest time to read a whole request, body included")
+	fs.DurationVar(&cfg.readHeaderTimeout, "read-header-timeout", 2*time.Second, "longest time to read a request's headers")
+	fs.DurationVar(&cfg.writeTimeout, "write-timeout", 10*time.Second, "longest time to write a response, event streams excepted")
+	fs.DurationVar(&cfg.idleTimeout, "idle-timeout", 120*time.Second, "how long an idle keep-alive connection stays open")
+	fs.Float64Var(&cfg.rateLimit, "rate-limit", 10, "requests per second allowed per client IP, 0 disables the limit")
+	fs.IntVar(&cfg.rateBurst, "rate-burst", 20, "requests a client IP can make in a burst")
+	fs.BoolVar(&cfg.trustProxy, "trust-proxy", false, "take the client IP from the last X-Forwarded-For entry, only safe behind a proxy")
+	fs.DurationVar(&cfg.cacheTTL, "cache-ttl", 30*time.Second, "how long post lists are cached, 0 disables the cache")
+	fs.IntVar(&cfg.cacheSize, "cache-size", 256, "most post lists kept in the cache")
+	webhooks := fs.String("webhooks", os.Getenv("WEBHOOK_URLS"), "comma-separated URLs notified of every post change (env WEBHOOK_URLS)")
+	fs.StringVar(&cfg.apiKey, "api-key", os.Getenv("API_KEY"), "key required to create, change or delete anything (env API_KEY)")
+	fs.StringVar(&cfg.auditLog, "audit-log", os.Getenv("AUDIT_LOG"), "file every change is appended to as JSON lines, empty keeps the audit log in memory (env AUDIT_LOG)")
+	authors := fs.String("authors", os.Getenv("AUTHORS"), "comma-separated author names allowed on posts, empty allows any (env AUTHORS)")
+	fs.BoolVar(&cfg.dev, "dev", false, "enable development endpoints like POST /admin/reset")
+	fs.StringVar(&cfg.sanitize, "sanitize", envOr("SANITIZE", "basic"), "how HTML in posts is made safe: basic keeps simple formatting, escape escapes it all (env SANITIZE)")
+	fs.StringVar(&cfg.logFormat, "log-format", envOr("LOG_FORMAT", "json"), "log output, text or json (env LOG_FORMAT)")
+	fs.StringVar(&cfg.accessLog, "access-log", os.Getenv("ACCESS_LOG"), "file request lines are written to as JSON, empty logs them to stderr with the rest (env ACCESS_LOG)")
+	fs.Int64Var(&cfg.accessLogSize, "access-log-size", 100<<20, "size in bytes the access log is rotated at")
+	fs.IntVar(&cfg.accessLogBackups, "access-log-backups", 5, "rotated access logs kept, as file.1 (newest) to file.N")
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	// Past the write timeout the connection is already gone, so the 503 would never arrive
+	if cfg.requestTimeout > 0 && cfg.writeTimeout > 0 && cfg.requestTimeout >= cfg.writeTimeout {
+		return config{}, fmt.Errorf("-request-timeout %v must be shorter than -write-timeout %v", cfg.requestTimeout, cfg.writeTimeout)
+	}
+
+	cfg.corsOrigins = splitList(*corsOrigins)
+	cfg.webhookURLs = splitList(*webhooks)
+	cfg.authors = splitList(*authors)
+	return cfg, nil
+}
+
+// envOr returns the env var, or def when it's unset
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,140 @@
+package main
+
+import "strings"
+
+// maxDiffEdits bounds how hard diffLines looks for a shortest diff, so a public
+// GET can't keep the CPU busy. Past it the lines left show up removed and re-added.
+const maxDiffEdits = 1000
+
+// diffLine is one line of a diff, Op is "+" for added, "-" for removed and " " for unchanged
+type diffLine struct {
+	Op   string `json:"op"`
+	Line string `json:"line"`
+}
+
+// diffLines compares a and b line by line with Myers' algorithm, which finds the
+// fewest lines to add and remove so that as much as possible shows up unchanged.
+// The linear space variant is used, memory grows with the lines, not their square.
+func diffLines(a, b string) []diffLine {
+	return appendDiff([]diffLine{}, splitLines(a), splitLines(b))
+}
+
+// appendDiff appends the diff of x and y to diff. What they start and end with is
+// unchanged, the rest is split where a shortest edit path crosses its middle.
+func appendDiff(diff []diffLine, x, y []string) []diffLine {
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		diff = append(diff, diffLine{" ", x[0]})
+		x, y = x[1:], y[1:]
+	}
+	common := 0
+	for common < len(x) && common < len(y) && x[len(x)-1-common] == y[len(y)-1-common] {
+		common++
+	}
+	suffix := x[len(x)-common:]
+	x, y = x[:len(x)-common], y[:len(y)-common]
+
+	if i, j, ok := middleSnake(x, y); ok {
+		diff = appendDiff(diff, x[:i], y[:j])
+		diff = appendDiff(diff, x[i:], y[j:])
+	} else {
+		for _, line := range x {
+			diff = append(diff, diffLine{"-", line})
+		}
+		for _, line := range y {
+			diff = append(diff, diffLine{"+", line})
+		}
+	}
+	for _, line := range suffix {
+		diff = append(diff, diffLine{" ", line})
+	}
+	return diff
+}
+
+// middleSnake walks a shortest edit path from both ends of x and y at once and
+// returns where the two walks meet. It's false when x or y is empty, there's
+// nothing left to split, and when the walks use up maxDiffEdits without meeting.
+func middleSnake(x, y []string) (int, int, bool) {
+	n, m := len(x), len(y)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+	maxD := min((n+m+1)/2, maxDiffEdits/2)
+	offset := maxD + 1
+	// forward[offset+k] is how far in x the walk from the start got on diagonal k
+	// (x minus y), backward the same for the walk from the end, counted from the end
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// With an odd delta the walks meet on a forward step, with an even one on a backward step
+	odd := delta%2 != 0
+	// Diagonals that ran off the edges are skipped from the next step on
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			i := offset + k
+			var x1 int
+			if k == -d || (k != d && forward[i-1] < forward[i+1]) {
+				x1 = forward[i+1]
+			} else {
+				x1 = forward[i-1] + 1
+			}
+			y1 := x1 - k
+			for x1 < n && y1 < m && x[x1] == y[y1] {
+				x1++
+				y1++
+			}
+			forward[i] = x1
+			switch {
+			case x1 > n:
+				fEnd += 2
+			case y1 > m:
+				fStart += 2
+			case odd:
+				if j := offset + delta - k; j >= 0 && j < len(backward) && backward[j] != -1 && x1 >= n-backward[j] {
+					return x1, y1, true
+				}
+			}
+		}
+
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			i := offset + k
+			var x2 int
+			if k == -d || (k != d && backward[i-1] < backward[i+1]) {
+				x2 = backward[i+1]
+			} else {
+				x2 = backward[i-1] + 1
+			}
+			y2 := x2 - k
+			for x2 < n && y2 < m && x[n-x2-1] == y[m-y2-1] {
+				x2++
+				y2++
+			}
+			backward[i] = x2
+			switch {
+			case x2 > n:
+				bEnd += 2
+			case y2 > m:
+				bStart += 2
+			case !odd:
+				if j := offset + delta - k; j >= 0 && j < len(forward) && forward[j] != -1 && forward[j] >= n-x2 {
+					x1 := forward[j]
+					return x1, x1 - (j - offset), true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// splitLines splits s on newlines, an empty string has no lines at all
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// maxEmbeddedComments caps how many comments ?embed=comments puts in each post,
+// the oldest first like GET /posts/{id}/comments. comment_count has the total.
+const maxEmbeddedComments = 10
+
+// embeds are what ?embed= can add to each post of a list
+var embeds = []string{"comment_count", "comments"}
+
+// parseEmbed reads ?embed=comment_count,comments into a set, writing a 400
+// for anything else. Without the param nothing is embedded.
+func parseEmbed(w http.ResponseWriter, r *http.Request) (map[string]bool, bool) {
+	embed := map[string]bool{}
+	for _, name := range splitList(r.URL.Query().Get("embed")) {
+		if !slices.Contains(embeds, name) {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, fmt.Sprintf("Unknown embed %q, use comment_count or comments", name))
+			return nil, false
+		}
+		embed[name] = true
+	}
+	return embed, true
+}
+
+// embedComments fills in the comment counts and comments asked for, saving
+// clients a request per post
+func (a *api) embedComments(posts []Post, embed map[string]bool) {
+	if len(embed) == 0 {
+		return
+	}
+	for i := range posts {
+		comments := approved(a.comments.List(posts[i].ID))
+		if embed["comment_count"] {
+			n := len(comments)
+			posts[i].CommentCount = &n
+		}
+		if embed["comments"] {
+			posts[i].Comments = comments[:min(len(comments), maxEmbeddedComments)]
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event names sent to webhooks and /posts/events
+const (
+	eventCreated = "post.created"
+	eventUpdated = "post.updated"
+	eventDeleted = "post.deleted"
+)
+
+// event is what webhooks and streams get when a post changes
+type event struct {
+	Event string `json:"event"`
+	Post  Post   `json:"post"`
+}
+
+// subscriberBuffer is how many events a slow subscriber can fall behind before it misses some
+const subscriberBuffer = 16
+
+// keepAlive is how often an idle stream gets a comment, so proxies don't
+// cut it and dead clients are noticed
+const keepAlive = 30 * time.Second
+
+// hub fans events out to every subscriber, the zero value is ready to use
+type hub struct {
+	mu     sync.Mutex
+	subs   map[chan event]struct{}
+	closed bool
+}
+
+// subscribe returns a channel of events and the func that stops them
+func (h *hub) subscribe() (<-chan event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = make(map[chan event]struct{})
+	}
+	ch := make(chan event, subscriberBuffer)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs, ch)
+	}
+}
+
+// publish never blocks, a subscriber whose buffer is full misses the event
+// rather than holding up the request that made the change
+func (h *hub) publish(e event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// close ends every stream, the server can't shut down while they're open
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+		delete(h.subs, ch)
+	}
+}
+
+// subscribers is how many streams are open
+func (h *hub) subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subs)
+}
+
+// streamEvents sends post changes as Server-Sent Events until the client goes away.
+// It runs on the request's own goroutine, so an idle stream costs nothing else.
+// Like the lists, a stream only hears about drafts when it asks for them with
+// ?status=, which takes the API key when there is one.
+func (a *api) streamEvents(w http.ResponseWriter, r *http.Request) {
+	drafts := includeDrafts(r)
+
+	rc := http.NewResponseController(w)
+
+	// Streams stay open far longer than -write-timeout allows a response
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	events, unsubscribe := a.events.subscribe()
+	defer unsubscribe()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			// A deleted or unpublished post is what it was when the event happened
+			if !e.Post.Published && !drafts {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedSize is how many of the latest posts go in the RSS feed
+const feedSize = 20
+
+// RSS 2.0 document, https://www.rssboard.org/rss-specification
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Author      string  `xml:"author"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// getFeed serves the RSS feed, ?tag=go narrows it down to one topic readers can
+// subscribe to on its own
+func (a *api) getFeed(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimSpace(r.URL.Query().Get("tag"))
+
+	// Drafts never go in the feed
+	posts := []Post{}
+	for _, post := range a.store.List(r.Context()) {
+		if post.Published && !post.Deleted && (tag == "" || hasTags(post, []string{tag})) {
+			posts = append(posts, post)
+		}
+	}
+
+	// Newest first, only the latest few
+	sort.Slice(posts, func(i, j int) bool { return posts[i].CreatedAt.After(posts[j].CreatedAt) })
+	posts = posts[:min(len(posts), feedSize)]
+
+	base := baseURL(r)
+	feed := rss{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Go Beyond JavaScript Blog",
+			Link:        base + "/posts",
+			Description: "The latest posts",
+			Items:       []rssItem{},
+		},
+	}
+	if tag != "" {
+		feed.Channel.Title += ": " + tag
+		feed.Channel.Link += "?" + url.Values{"tag": {tag}}.Encode()
+		feed.Channel.Description = "The latest posts tagged " + tag
+	}
+	for _, post := range posts {
+		link := base + a.postPath(post)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       plainText(post.Title),
+			Link:        link,
+			Description: post.Content,
+			Author:      plainText(post.Author),
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     post.CreatedAt.Format(time.RFC1123Z),
+		})
+	}
+
+	// encoding/xml takes care of escaping the post contents
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		slog.ErrorContext(r.Context(), "encoding feed", "err", err)
+	}
+}
+
+// baseURL is the scheme and host the client used to reach us
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// postFields are the JSON names of Post's fields, the ones ?fields= can pick from
+var postFields = jsonFields(reflect.TypeOf(Post{}))
+
+// jsonFields lists the JSON names of a struct's encoded fields
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// parseFields reads ?fields=id,title into a set, nil means every field. The
+// id always comes along so the posts stay addressable. Unknown names are
+// dropped, or a 400 with -strict-fields.
+func (a *api) parseFields(w http.ResponseWriter, r *http.Request) (map[string]bool, bool) {
+	if !r.URL.Query().Has("fields") {
+		return nil, true
+	}
+
+	fields := map[string]bool{"id": true}
+	for _, name := range splitList(r.URL.Query().Get("fields")) {
+		if !postFields[name] {
+			if a.cfg.strictFields {
+				writeError(w, http.StatusBadRequest, codeInvalidQuery, fmt.Sprintf("Unknown field %q in fields", name))
+				return nil, false
+			}
+			continue
+		}
+		fields[name] = true
+	}
+	return fields, true
+}
+
+// sparsePost is the post's JSON object with only the given fields left
+func sparsePost(post Post, fields map[string]bool) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(post)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	for name := range obj {
+		if !fields[name] {
+			delete(obj, name)
+		}
+	}
+	return obj, nil
+}
+
+// sparseList is a PostList whose posts only have some of their fields,
+// its Data hides the embedded list's
+type sparseList struct {
+	PostList
+	Data []map[string]json.RawMessage `json:"data"`
+}
+
+// marshalFields is marshal for a Post or PostList, leaving out the fields not
+// asked for. Only JSON can do that, XML always has every field.
+func marshalFields(format string, v any, fields map[string]bool) ([]byte, error) {
+	if fields == nil || format != formatJSON {
+		return marshal(format, v)
+	}
+
+	switch v := v.(type) {
+	case Post:
+		obj, err := sparsePost(v, fields)
+		if err != nil {
+			return nil, err
+		}
+		return marshal(format, obj)
+	case PostList:
+		list := sparseList{PostList: v, Data: make([]map[string]json.RawMessage, len(v.Data))}
+		for i, post := range v.Data {
+			var err error
+			if list.Data[i], err = sparsePost(post, fields); err != nil {
+				return nil, err
+			}
+		}
+		return marshal(format, list)
+	}
+	return marshal(format, v)
+}
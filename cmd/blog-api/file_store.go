@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// FileStore is a MemStore that writes the whole slice to a JSON file after every change,
+// so posts survive a restart
+type FileStore struct {
+	*MemStore
+	path string
+
+	// saveMu makes sure only one write to disk happens at a time
+	saveMu sync.Mutex
+}
+
+// NewFileStore loads the posts from path, falling back to the sample data when the file doesn't exist yet
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemStore: NewMemStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		initializeSampleData(s.MemStore)
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []Post
+	if err := json.Unmarshal(data, &posts); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	// Posts saved before drafts existed have no published field, they were all public
+	var published []struct {
+		Published *bool `json:"published"`
+	}
+	json.Unmarshal(data, &published)
+
+	// Carry on numbering after the highest ID we loaded, and give
+	// posts saved before slugs or UUIDs existed one
+	backfilled := false
+	for i, post := range posts {
+		if published[i].Published == nil {
+			post.Published = true
+		}
+		if post.ID >= s.nextID {
+			s.nextID = post.ID + 1
+		}
+		if post.Slug == "" {
+			s.setSlug(&post)
+		}
+		// Unlike slugs, UUIDs are random, so they have to be saved right away
+		if post.UUID == "" {
+			post.UUID = uuid.NewString()
+			backfilled = true
+		}
+		// Versions start at 1, older files don't have them
+		post.Version = max(post.Version, 1)
+		post.Tags = normalizeTags(post.Tags)
+		setReadingStats(&post)
+		s.posts = append(s.posts, post)
+	}
+	if backfilled {
+		if err := s.Save(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) Create(ctx context.Context, p Post) (Post, error) {
+	p, err := s.MemStore.Create(ctx, p)
+	if err == nil {
+		s.persist()
+	}
+	return p, err
+}
+
+func (s *FileStore) CreateMany(ctx context.Context, posts []Post) ([]Post, error) {
+	posts, err := s.MemStore.CreateMany(ctx, posts)
+	if err == nil {
+		s.persist()
+	}
+	return posts, err
+}
+
+func (s *FileStore) Update(ctx context.Context, id int, p Post) (Post, error) {
+	p, err := s.MemStore.Update(ctx, id, p)
+	if err == nil {
+		s.persist()
+	}
+	return p, err
+}
+
+func (s *FileStore) AppendContent(ctx context.Context, id int, content string) (Post, error) {
+	p, err := s.MemStore.AppendContent(ctx, id, content)
+	if err == nil {
+		s.persist()
+	}
+	return p, err
+}
+
+func (s *FileStore) AddAttachment(ctx context.Context, id int, att Attachment) (Post, error) {
+	post, err := s.MemStore.AddAttachment(ctx, id, att)
+	if err == nil {
+		s.persist()
+	}
+	return post, err
+}
+
+func (s *FileStore) AddLikes(ctx context.Context, id, delta int) (int, error) {
+	likes, err := s.MemStore.AddLikes(ctx, id, delta)
+	if err == nil {
+		s.persist()
+	}
+	return likes, err
+}
+
+func (s *FileStore) AddReaction(ctx context.Context, id int, emoji string) (map[string]int, error) {
+	reactions, err := s.MemStore.AddReaction(ctx, id, emoji)
+	if err == nil {
+		s.persist()
+	}
+	return reactions, err
+}
+
+func (s *FileStore) AddViews(ctx context.Context, id, n int) error {
+	err := s.MemStore.AddViews(ctx, id, n)
+	if err == nil {
+		s.persist()
+	}
+	return err
+}
+
+func (s *FileStore) Delete(ctx context.Context, id int) bool {
+	ok := s.MemStore.Delete(ctx, id)
+	if ok {
+		s.persist()
+	}
+	return ok
+}
+
+func (s *FileStore) Reset() error {
+	initializeSampleData(s.MemStore)
+	return s.Save()
+}
+
+func (s *FileStore) Seed(ctx context.Context, posts []Post) error {
+	if err := s.MemStore.Seed(ctx, posts); err != nil {
+		return err
+	}
+	return s.Save()
+}
+
+func (s *FileStore) Restore(ctx context.Context, posts []Post) error {
+	if err := s.MemStore.Restore(ctx, posts); err != nil {
+		return err
+	}
+	return s.Save()
+}
+
+// Save writes all posts to a temp file and renames it over the real one,
+// so a crash mid-write can't leave a half written file behind
+func (s *FileStore) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	data, err := json.MarshalIndent(s.List(context.Background()), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Close flushes the posts to disk one last time
+func (s *FileStore) Close() error {
+	return s.Save()
+}
+
+// persist saves after a mutation, the change is already in memory so a failed write is only logged
+func (s *FileStore) persist() {
+	if err := s.Save(); err != nil {
+		slog.Error("saving posts", "path", s.path, "err", err)
+	}
+}
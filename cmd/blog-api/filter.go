@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// postFilter holds the list filters read from the query string
+type postFilter struct {
+	q      string   // search term, folded by foldText
+	author string   // exact author, ignoring case
+	tags   []string // every one of these must be on the post
+	status string   // statusPublished, statusDraft or statusAll
+	fuzzy  bool     // match q with typo tolerance instead of as a substring
+
+	// Only posts created in between, both ends included, zero means open-ended
+	from, to time.Time
+}
+
+// parseFilter reads the filters, failing when a date can't be parsed
+func parseFilter(r *http.Request) (postFilter, error) {
+	query := r.URL.Query()
+	f := postFilter{
+		q:      foldText(query.Get("q")),
+		author: query.Get("author"),
+		tags:   query["tag"],
+		fuzzy:  query.Get("fuzzy") == "true",
+	}
+
+	var err error
+	if f.status, err = postStatus(r); err != nil {
+		return postFilter{}, err
+	}
+	if f.from, err = parseDate(query.Get("from"), false); err != nil {
+		return postFilter{}, fmt.Errorf("invalid from date %q, use YYYY-MM-DD or RFC 3339", query.Get("from"))
+	}
+	if f.to, err = parseDate(query.Get("to"), true); err != nil {
+		return postFilter{}, fmt.Errorf("invalid to date %q, use YYYY-MM-DD or RFC 3339", query.Get("to"))
+	}
+	if !f.from.IsZero() && !f.to.IsZero() && f.from.After(f.to) {
+		return postFilter{}, fmt.Errorf("from date is after to date")
+	}
+	return f, nil
+}
+
+// parseDate reads an RFC 3339 time or a plain date in UTC, "" is the zero time.
+// A plain date as the end of a range means the end of that day.
+func parseDate(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+func (f postFilter) match(post Post) bool {
+	if post.Deleted || (f.status == statusPublished && !post.Published) || (f.status == statusDraft && post.Published) {
+		return false
+	}
+	if f.author != "" && !strings.EqualFold(plainText(post.Author), f.author) {
+		return false
+	}
+	if (!f.from.IsZero() && post.CreatedAt.Before(f.from)) || (!f.to.IsZero() && post.CreatedAt.After(f.to)) {
+		return false
+	}
+	if f.fuzzy && f.q != "" {
+		return fuzzyScore(post, f.q) > 0 && hasTags(post, f.tags)
+	}
+	return matchesSearch(post, f.q) && hasTags(post, f.tags)
+}
+
+// Values of ?status=, which posts a list has
+const (
+	statusPublished = "published"
+	statusDraft     = "draft"
+	statusAll       = "all"
+)
+
+// postStatus reads ?status=, published when it's missing. The older
+// ?include_drafts=true still works and means all.
+func postStatus(r *http.Request) (string, error) {
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+		if r.URL.Query().Get("include_drafts") == "true" {
+			return statusAll, nil
+		}
+		return statusPublished, nil
+	case statusPublished, statusDraft, statusAll:
+		return status, nil
+	default:
+		return "", fmt.Errorf("invalid status %q, use published, draft or all", status)
+	}
+}
+
+// includeDrafts reports whether the caller asked to see unpublished posts too
+func includeDrafts(r *http.Request) bool {
+	status, err := postStatus(r)
+	return err == nil && status != statusPublished
+}
+
+// visible reports whether a single post can be shown for this request,
+// posts in the trash never are
+func visible(post Post, r *http.Request) bool {
+	return !post.Deleted && (post.Published || includeDrafts(r))
+}
+
+// matchesSearch reports whether the folded term q appears in the title or
+// content, ignoring case and accents
+func matchesSearch(post Post, q string) bool {
+	if q == "" {
+		return true
+	}
+	return strings.Contains(foldText(plainText(post.Title)), q) ||
+		strings.Contains(foldText(plainText(post.Content)), q)
+}
+
+// hasTags reports whether the post carries all the given tags, ignoring case
+func hasTags(post Post, tags []string) bool {
+	for _, want := range tags {
+		found := false
+		for _, tag := range post.Tags {
+			if strings.EqualFold(tag, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeTags trims the tags and drops empty ones, always returning a non-nil slice
+func normalizeTags(tags []string) []string {
+	clean := []string{}
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			clean = append(clean, tag)
+		}
+	}
+	return clean
+}
+
+// sortKeys are what ?sort= can order posts by, each comparing ascending
+var sortKeys = map[string]func(a, b Post) int{
+	"id":         func(a, b Post) int { return cmp.Compare(a.ID, b.ID) },
+	"title":      func(a, b Post) int { return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)) },
+	"author":     func(a, b Post) int { return strings.Compare(strings.ToLower(a.Author), strings.ToLower(b.Author)) },
+	"created_at": func(a, b Post) int { return a.CreatedAt.Compare(b.CreatedAt) },
+	"score":      func(a, b Post) int { return cmp.Compare(a.Score, b.Score) },
+}
+
+// sortPosts orders posts in place by comma-separated keys like "author,-created_at",
+// each one breaking the ties of the keys before it. A leading "-" sorts that key
+// descending, and posts still tied at the end are in ID order.
+func sortPosts(posts []Post, keys string) error {
+	var compares []func(a, b Post) int
+	for _, key := range splitList(keys) {
+		compare, ok := sortKeys[strings.TrimPrefix(key, "-")]
+		if !ok {
+			return fmt.Errorf("unknown sort key %q", key)
+		}
+		if strings.HasPrefix(key, "-") {
+			asc := compare
+			compare = func(a, b Post) int { return asc(b, a) }
+		}
+		compares = append(compares, compare)
+	}
+
+	slices.SortStableFunc(posts, func(a, b Post) int {
+		for _, compare := range compares {
+			if n := compare(a, b); n != 0 {
+				return n
+			}
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return nil
+}
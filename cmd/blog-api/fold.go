@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// accentFolders hands out transformers that decompose text, drop the accents
+// that come apart from their letters and compose what's left, so a search for
+// "cafe" finds "café". A transformer keeps state, so each call takes its own.
+var accentFolders = sync.Pool{New: func() any {
+	return transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+}}
+
+// foldRune is r lowercased and without its accent
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		return unicode.ToLower(r)
+	}
+	// A combining mark on its own folds to nothing, it stays what it is
+	if folded, size := utf8.DecodeRuneInString(foldText(string(r))); size > 0 {
+		return folded
+	}
+	return r
+}
+
+// isMark reports whether r is a combining mark, like the accent of a decomposed "é"
+func isMark(r rune) bool {
+	return unicode.Is(unicode.Mn, r)
+}
+
+// foldText is s the way searches compare it: lowercased, accents dropped.
+// It's only ever compared, what's stored and returned keeps its accents.
+func foldText(s string) string {
+	s = strings.ToLower(s)
+	folder := accentFolders.Get().(transform.Transformer)
+	defer accentFolders.Put(folder)
+	folded, _, err := transform.String(folder, s)
+	if err != nil {
+		return s
+	}
+	return folded
+}
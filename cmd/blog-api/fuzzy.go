@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// fuzzyScore rates how well the post matches the folded search q, allowing typos.
+// Every word of q has to be close to some word of the title or content, the score is
+// 1 for exact matches and goes down with each edit needed, 0 means no match.
+func fuzzyScore(post Post, q string) float64 {
+	terms := words(q)
+	if len(terms) == 0 {
+		return 0
+	}
+	text := append(words(plainText(post.Title)), words(plainText(post.Content))...)
+
+	total := 0.0
+	for _, term := range terms {
+		best := 0.0
+		limit := maxEdits(term)
+		for _, word := range text {
+			d := levenshtein(term, word)
+			if d > limit {
+				continue
+			}
+			best = math.Max(best, 1-float64(d)/float64(len([]rune(term))+1))
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+
+	// Two decimals are plenty for ranking and read better in the response
+	return math.Round(total/float64(len(terms))*100) / 100
+}
+
+// maxEdits is how many typos a word may have, short words have to be spelled right
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	case n <= 9:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// words splits s into words folded like foldText, dropping punctuation
+func words(s string) []string {
+	return strings.FieldsFunc(foldText(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// levenshtein counts the single rune insertions, deletions and substitutions
+// needed to turn a into b, keeping only the previous row of the table
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
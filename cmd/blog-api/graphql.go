@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+// graphqlRequest is the body of POST /graphql
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+
+	// Some clients always send extensions, none are supported
+	Extensions map[string]any `json:"extensions"`
+}
+
+// isGraphQL reports whether the request is for the GraphQL endpoint
+func isGraphQL(r *http.Request) bool {
+	return r.URL.Path == "/graphql"
+}
+
+// graphqlRequestKey is where resolvers find the HTTP request they run for
+type graphqlRequestKey struct{}
+
+// serveGraphQL runs a query or mutation against the schema. Like any GraphQL
+// server it answers 200 when the operation fails, with the errors in the body.
+func (a *api) serveGraphQL(schema graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidFields, "A query is required")
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        context.WithValue(r.Context(), graphqlRequestKey{}, r),
+		})
+		writeJSON(w, r, http.StatusOK, result)
+	}
+}
+
+// graphqlError is an error in a GraphQL response, with the error code the
+// REST API would use and any invalid fields in its extensions
+type graphqlError struct {
+	message string
+	code    string
+	fields  []FieldError
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]any {
+	ext := map[string]any{"code": e.code}
+	if len(e.fields) > 0 {
+		ext["errors"] = e.fields
+	}
+	return ext
+}
+
+// graphqlStoreError maps the store errors writeCreateError and writeUpdateError
+// handle to GraphQL errors
+func graphqlStoreError(r *http.Request, err error, action string) error {
+	var titleErr *TitleTakenError
+	switch {
+	case errors.Is(err, ErrPostNotFound):
+		return &graphqlError{message: "Post not found", code: codeNotFound}
+	case errors.Is(err, ErrVersionMismatch):
+		return &graphqlError{message: "Post was changed by someone else, fetch it and try again", code: codeVersionMismatch}
+	case errors.Is(err, ErrPostExists):
+		return &graphqlError{message: "A post with this ID already exists", code: codeConflict}
+	case errors.As(err, &titleErr):
+		return &graphqlError{message: fmt.Sprintf("Post %d already has this title", titleErr.ID), code: codeTitleTaken}
+	default:
+		slog.ErrorContext(r.Context(), action, "err", err)
+		return &graphqlError{message: "Error " + action, code: codeInternal}
+	}
+}
+
+// graphqlWriter is the request a mutation runs for, once it's made sure the
+// request sent the API key when there is one
+func (a *api) graphqlWriter(p graphql.ResolveParams) (*http.Request, error) {
+	r := p.Context.Value(graphqlRequestKey{}).(*http.Request)
+	if a.cfg.apiKey != "" {
+		if problem := apiKeyProblem(r, a.cfg.apiKey); problem != "" {
+			return nil, &graphqlError{message: problem, code: codeUnauthorized}
+		}
+	}
+	return r, nil
+}
+
+// graphqlPostID reads the id argument like postID reads the URL
+func (a *api) graphqlPostID(p graphql.ResolveParams) (int, error) {
+	id, err := a.postID(p.Context, p.Args["id"].(string))
+	if err != nil {
+		return 0, &graphqlError{message: "Invalid post ID", code: codeInvalidID}
+	}
+	return id, nil
+}
+
+// postFromInput converts a PostInput argument. Like a JSON body, it's only
+// what the client sent, the resolvers decide what's kept.
+func postFromInput(in map[string]any) Post {
+	var post Post
+	post.Title, _ = in["title"].(string)
+	post.Content, _ = in["content"].(string)
+	post.Author, _ = in["author"].(string)
+	post.Slug, _ = in["slug"].(string)
+	post.Published, _ = in["published"].(bool)
+	if tags, ok := in["tags"].([]any); ok {
+		for _, tag := range tags {
+			if tag, ok := tag.(string); ok {
+				post.Tags = append(post.Tags, tag)
+			}
+		}
+	}
+	return post
+}
+
+// graphqlSchema builds the schema of POST /graphql. Post has the fields of the
+// REST Post by their JSON names, which the default resolver finds by the tags.
+// The schema is the same every time, so failing to build it is a bug.
+func (a *api) graphqlSchema() graphql.Schema {
+	nonNull := graphql.NewNonNull
+
+	attachment := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Attachment",
+		Fields: graphql.Fields{
+			"id":           {Type: nonNull(graphql.String)},
+			"filename":     {Type: nonNull(graphql.String)},
+			"url":          {Type: nonNull(graphql.String)},
+			"size":         {Type: nonNull(graphql.Int)},
+			"content_type": {Type: nonNull(graphql.String)},
+			"created_at":   {Type: nonNull(graphql.DateTime)},
+		},
+	})
+	reaction := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Reaction",
+		Fields: graphql.Fields{
+			"emoji": {Type: nonNull(graphql.String)},
+			"count": {Type: nonNull(graphql.Int)},
+		},
+	})
+	post := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Post",
+		Fields: graphql.Fields{
+			"id":         {Type: nonNull(graphql.Int)},
+			"uuid":       {Type: nonNull(graphql.String)},
+			"title":      {Type: nonNull(graphql.String)},
+			"content":    {Type: nonNull(graphql.String)},
+			"author":     {Type: nonNull(graphql.String)},
+			"slug":       {Type: nonNull(graphql.String)},
+			"tags":       {Type: graphql.NewList(nonNull(graphql.String))},
+			"published":  {Type: nonNull(graphql.Boolean)},
+			"deleted":    {Type: nonNull(graphql.Boolean)},
+			"deleted_at": {Type: graphql.DateTime},
+			"version":    {Type: nonNull(graphql.Int)},
+			"likes":      {Type: nonNull(graphql.Int)},
+			"views":      {Type: nonNull(graphql.Int)},
+
+			// GraphQL has no maps, each emoji is an entry
+			"reactions": {
+				Type: nonNull(graphql.NewList(nonNull(reaction))),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					counts := p.Source.(Post).Reactions
+					reactions := []map[string]any{}
+					for _, emoji := range slices.Sorted(maps.Keys(counts)) {
+						reactions = append(reactions, map[string]any{"emoji": emoji, "count": counts[emoji]})
+					}
+					return reactions, nil
+				},
+			},
+			"attachments":          {Type: graphql.NewList(nonNull(attachment))},
+			"word_count":           {Type: nonNull(graphql.Int)},
+			"reading_time_minutes": {Type: nonNull(graphql.Int)},
+			"created_at":           {Type: nonNull(graphql.DateTime)},
+			"updated_at":           {Type: nonNull(graphql.DateTime)},
+		},
+	})
+	postInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PostInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":     {Type: nonNull(graphql.String)},
+			"content":   {Type: nonNull(graphql.String)},
+			"author":    {Type: nonNull(graphql.String)},
+			"slug":      {Type: graphql.String},
+			"tags":      {Type: graphql.NewList(nonNull(graphql.String))},
+			"published": {Type: graphql.Boolean},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			// Published posts in ID order, paged like GET /posts
+			"posts": {
+				Type: nonNull(graphql.NewList(nonNull(post))),
+				Args: graphql.FieldConfigArgument{
+					"limit":  {Type: graphql.Int, DefaultValue: defaultLimit},
+					"offset": {Type: graphql.Int, DefaultValue: 0},
+					"tag":    {Type: graphql.String},
+					"author": {Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					filter := postFilter{status: statusPublished}
+					filter.author, _ = p.Args["author"].(string)
+					if tag, ok := p.Args["tag"].(string); ok {
+						filter.tags = []string{tag}
+					}
+					matched := []Post{}
+					for _, post := range a.store.List(p.Context) {
+						if filter.match(post) {
+							matched = append(matched, post)
+						}
+					}
+
+					limit, _ := p.Args["limit"].(int)
+					if limit <= 0 {
+						limit = defaultLimit
+					}
+					limit = min(limit, maxLimit)
+					offset, _ := p.Args["offset"].(int)
+					start := min(max(offset, 0), len(matched))
+					return matched[start:min(start+limit, len(matched))], nil
+				},
+			},
+
+			// One published post, null when there's none with that ID
+			"post": {
+				Type: post,
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: nonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := a.graphqlPostID(p)
+					if err != nil {
+						return nil, err
+					}
+					post, ok := a.findPost(p.Context, id)
+					if !ok || !post.Published {
+						return nil, nil
+					}
+					a.views.count(id)
+					return post, nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			// Like POST /posts
+			"createPost": {
+				Type: nonNull(post),
+				Args: graphql.FieldConfigArgument{
+					"input": {Type: nonNull(postInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					r, err := a.graphqlWriter(p)
+					if err != nil {
+						return nil, err
+					}
+					post := postFromInput(p.Args["input"].(map[string]any))
+					if errs := a.prepareNewPost(&post); len(errs) > 0 {
+						return nil, &graphqlError{message: "Some fields are invalid", code: codeInvalidFields, fields: errs}
+					}
+
+					post, err = a.store.Create(p.Context, post)
+					if err != nil {
+						return nil, graphqlStoreError(r, err, "creating post")
+					}
+					a.emit(r, eventCreated, post)
+					return post, nil
+				},
+			},
+
+			// Like PUT /posts/{id}, version stands in for If-Match
+			"updatePost": {
+				Type: nonNull(post),
+				Args: graphql.FieldConfigArgument{
+					"id":      {Type: nonNull(graphql.ID)},
+					"input":   {Type: nonNull(postInput)},
+					"version": {Type: graphql.Int},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					r, err := a.graphqlWriter(p)
+					if err != nil {
+						return nil, err
+					}
+					id, err := a.graphqlPostID(p)
+					if err != nil {
+						return nil, err
+					}
+
+					updated := postFromInput(p.Args["input"].(map[string]any))
+					if errs := a.checkFields(&updated.Title, &updated.Content, &updated.Author, "required"); len(errs) > 0 {
+						return nil, &graphqlError{message: "Some fields are invalid", code: codeInvalidFields, fields: errs}
+					}
+					updated.Tags = normalizeTags(updated.Tags)
+
+					current, ok := a.findPost(p.Context, id)
+					if !ok {
+						return nil, &graphqlError{message: "Post not found", code: codeNotFound}
+					}
+					if version, ok := p.Args["version"].(int); ok && version != current.Version {
+						return nil, &graphqlError{message: fmt.Sprintf("Post was changed since version %d", version), code: codeVersionMismatch}
+					}
+					updated.Version = current.Version
+
+					// Without an explicit slug, keep the old one unless the title changed
+					switch {
+					case updated.Slug != "":
+						updated.Slug = slugify(updated.Slug)
+					case updated.Title == current.Title:
+						updated.Slug = current.Slug
+					}
+
+					updated, err = a.store.Update(p.Context, id, updated)
+					if err != nil {
+						return nil, graphqlStoreError(r, err, "updating post")
+					}
+					a.saveRevision(current, updated)
+					a.emit(r, eventUpdated, updated)
+					return updated, nil
+				},
+			},
+
+			// Like DELETE /posts/{id}, answering with the post as it's left
+			"deletePost": {
+				Type: nonNull(post),
+				Args: graphql.FieldConfigArgument{
+					"id": {Type: nonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					r, err := a.graphqlWriter(p)
+					if err != nil {
+						return nil, err
+					}
+					id, err := a.graphqlPostID(p)
+					if err != nil {
+						return nil, err
+					}
+					post, ok := a.findPost(p.Context, id)
+					if !ok {
+						return nil, &graphqlError{message: "Post not found", code: codeNotFound}
+					}
+
+					event := eventDeleted
+					if a.cfg.unpublishOnDelete && post.Published {
+						post.Published = false
+						event = eventUpdated
+					} else {
+						now := time.Now().UTC()
+						post.Deleted, post.DeletedAt = true, &now
+					}
+					post, err = a.store.Update(p.Context, id, post)
+					if err != nil {
+						return nil, graphqlStoreError(r, err, "deleting post")
+					}
+					a.emit(r, event, post)
+					return post, nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(fmt.Sprintf("building the GraphQL schema: %v", err))
+	}
+	return schema
+}
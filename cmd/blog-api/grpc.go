@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/edaywalid/golang-discovery-workshop/cmd/blog-api/blogpb"
+)
+
+// grpcServer is BlogService on top of the same api the HTTP handlers use, so
+// both see the same store, validation, audit log and webhooks
+type grpcServer struct {
+	blogpb.UnimplementedBlogServiceServer
+	a *api
+}
+
+// newGRPCServer returns a gRPC server with BlogService registered. With an
+// API key set, calls need it just like HTTP requests do.
+func newGRPCServer(a *api) *grpc.Server {
+	var opts []grpc.ServerOption
+	if a.cfg.apiKey != "" {
+		opts = append(opts, grpc.UnaryInterceptor(grpcAPIKey(a.cfg.apiKey)))
+	}
+	s := grpc.NewServer(opts...)
+	blogpb.RegisterBlogServiceServer(s, &grpcServer{a: a})
+	return s
+}
+
+// stopGRPC lets in-flight calls finish, cutting them off once ctx is done.
+// A nil server, with gRPC off, is left alone.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	if s == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// grpcAPIKey is requireAPIKey for gRPC. The key comes in the "x-api-key" or
+// "authorization: Bearer" metadata, reads only need it to see drafts.
+func grpcAPIKey(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !grpcWrite(info.FullMethod) && !draftsRequested(req) {
+			return handler(ctx, req)
+		}
+
+		// Metadata becomes the headers, so the key is read the same way
+		if problem := apiKeyProblem(grpcRequest(ctx, nil), key); problem != "" {
+			return nil, status.Error(codes.Unauthenticated, problem)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// grpcWrite reports whether the method changes anything, like isWrite
+func grpcWrite(method string) bool {
+	switch method {
+	case blogpb.BlogService_CreatePost_FullMethodName, blogpb.BlogService_UpdatePost_FullMethodName, blogpb.BlogService_DeletePost_FullMethodName:
+		return true
+	}
+	return false
+}
+
+// draftsRequested reports whether a read asks to see unpublished posts, like includeDrafts
+func draftsRequested(req any) bool {
+	switch req := req.(type) {
+	case *blogpb.ListPostsRequest:
+		return req.Status != "" && req.Status != statusPublished
+	case *blogpb.GetPostRequest:
+		return req.IncludeDrafts
+	}
+	return false
+}
+
+// grpcRequest dresses a call up as the HTTP request the shared helpers read,
+// the filters their query string and the audit log the client's address
+func grpcRequest(ctx context.Context, query url.Values) *http.Request {
+	r := &http.Request{URL: &url.URL{RawQuery: query.Encode()}, Header: http.Header{}}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	return r.WithContext(ctx)
+}
+
+// changed is emit for gRPC calls. They don't pass through the list cache's
+// middleware, so they drop the cached lists themselves.
+func (s *grpcServer) changed(ctx context.Context, name string, post Post) {
+	if s.a.cache != nil {
+		s.a.cache.invalidate()
+	}
+	s.a.emit(grpcRequest(ctx, nil), name, post)
+}
+
+// ListPosts pages through the posts like GET /posts. Unlike there, the posts
+// come with their full content.
+func (s *grpcServer) ListPosts(ctx context.Context, req *blogpb.ListPostsRequest) (*blogpb.ListPostsResponse, error) {
+	query := url.Values{"tag": req.Tags}
+	for key, value := range map[string]string{"q": req.Q, "author": req.Author, "status": req.Status} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	filter, err := parseFilter(grpcRequest(ctx, query))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	matched := []Post{}
+	for _, post := range s.a.store.List(ctx) {
+		if filter.match(post) {
+			matched = append(matched, post)
+		}
+	}
+	if err := sortPosts(matched, req.Sort); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Same paging defaults as the query string
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	limit = min(limit, maxLimit)
+	offset := max(int(req.Offset), 0)
+	start := min(offset, len(matched))
+	end := min(start+limit, len(matched))
+
+	resp := &blogpb.ListPostsResponse{
+		Posts:  []*blogpb.Post{},
+		Total:  int32(len(matched)),
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	}
+	for _, post := range matched[start:end] {
+		resp.Posts = append(resp.Posts, postToProto(post))
+	}
+	return resp, nil
+}
+
+// GetPost fetches one post like GET /posts/{id}, counting it as a view
+func (s *grpcServer) GetPost(ctx context.Context, req *blogpb.GetPostRequest) (*blogpb.Post, error) {
+	id, err := s.a.postID(ctx, req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid post ID")
+	}
+
+	// Drafts look like they don't exist unless asked for
+	post, ok := s.a.findPost(ctx, id)
+	if !ok || (!post.Published && !req.IncludeDrafts) {
+		return nil, status.Error(codes.NotFound, "Post not found")
+	}
+	s.a.views.count(id)
+	return postToProto(post), nil
+}
+
+// CreatePost creates a post like POST /posts
+func (s *grpcServer) CreatePost(ctx context.Context, req *blogpb.CreatePostRequest) (*blogpb.Post, error) {
+	if req.Post == nil {
+		return nil, status.Error(codes.InvalidArgument, "A post is required")
+	}
+	post := postFromProto(req.Post)
+	if errs := s.a.prepareNewPost(&post); len(errs) > 0 {
+		return nil, fieldErrors(errs)
+	}
+
+	post, err := s.a.store.Create(ctx, post)
+	if err != nil {
+		return nil, grpcError(ctx, err, "creating post")
+	}
+	s.changed(ctx, eventCreated, post)
+	return postToProto(post), nil
+}
+
+// UpdatePost replaces a post like PUT /posts/{id}. A version in the post
+// stands in for If-Match.
+func (s *grpcServer) UpdatePost(ctx context.Context, req *blogpb.UpdatePostRequest) (*blogpb.Post, error) {
+	id, err := s.a.postID(ctx, req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid post ID")
+	}
+	if req.Post == nil {
+		return nil, status.Error(codes.InvalidArgument, "A post is required")
+	}
+
+	updated := postFromProto(req.Post)
+	if errs := s.a.checkFields(&updated.Title, &updated.Content, &updated.Author, "required"); len(errs) > 0 {
+		return nil, fieldErrors(errs)
+	}
+	updated.Tags = normalizeTags(updated.Tags)
+
+	current, ok := s.a.findPost(ctx, id)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Post not found")
+	}
+	if updated.Version != 0 && updated.Version != current.Version {
+		return nil, status.Error(codes.FailedPrecondition, "Post was changed since version "+strconv.Itoa(updated.Version))
+	}
+	updated.Version = current.Version
+	updated.Deleted, updated.DeletedAt = false, nil
+
+	// Without an explicit slug, keep the old one unless the title changed
+	switch {
+	case updated.Slug != "":
+		updated.Slug = slugify(updated.Slug)
+	case updated.Title == current.Title:
+		updated.Slug = current.Slug
+	}
+
+	updated, err = s.a.store.Update(ctx, id, updated)
+	if err != nil {
+		return nil, grpcError(ctx, err, "updating post")
+	}
+	s.a.saveRevision(current, updated)
+	s.changed(ctx, eventUpdated, updated)
+	return postToProto(updated), nil
+}
+
+// DeletePost moves a post to the trash like DELETE /posts/{id}, or with
+// -unpublish-on-delete turns a published one back into a draft
+func (s *grpcServer) DeletePost(ctx context.Context, req *blogpb.DeletePostRequest) (*blogpb.DeletePostResponse, error) {
+	id, err := s.a.postID(ctx, req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid post ID")
+	}
+	post, ok := s.a.findPost(ctx, id)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Post not found")
+	}
+
+	event := eventDeleted
+	if s.a.cfg.unpublishOnDelete && post.Published {
+		post.Published = false
+		event = eventUpdated
+	} else {
+		now := time.Now().UTC()
+		post.Deleted, post.DeletedAt = true, &now
+	}
+	post, err = s.a.store.Update(ctx, id, post)
+	if err != nil {
+		return nil, grpcError(ctx, err, "deleting post")
+	}
+	s.changed(ctx, event, post)
+	return &blogpb.DeletePostResponse{Post: postToProto(post)}, nil
+}
+
+// grpcError maps the store errors writeCreateError and writeUpdateError
+// handle to gRPC codes
+func grpcError(ctx context.Context, err error, action string) error {
+	var titleErr *TitleTakenError
+	switch {
+	case errors.Is(err, ErrPostNotFound):
+		return status.Error(codes.NotFound, "Post not found")
+	case errors.Is(err, ErrVersionMismatch):
+		return status.Error(codes.FailedPrecondition, "Post was changed by someone else, fetch it and try again")
+	case errors.Is(err, ErrPostExists):
+		return status.Error(codes.AlreadyExists, "A post with this ID already exists")
+	case errors.As(err, &titleErr):
+		return status.Error(codes.AlreadyExists, fmt.Sprintf("Post %d already has this title", titleErr.ID))
+	default:
+		slog.ErrorContext(ctx, action, "err", err)
+		return status.Error(codes.Internal, "Error "+action)
+	}
+}
+
+// fieldErrors is writeFieldErrors for gRPC, every invalid field is a
+// violation in the status details
+func fieldErrors(errs []FieldError) error {
+	st := status.New(codes.InvalidArgument, "Some fields are invalid")
+	details := &errdetails.BadRequest{}
+	for _, e := range errs {
+		details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: e.Field, Description: e.Message})
+	}
+	if withDetails, err := st.WithDetails(details); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// postToProto converts a post for a gRPC response
+func postToProto(p Post) *blogpb.Post {
+	pb := &blogpb.Post{
+		Id:                 int64(p.ID),
+		Uuid:               p.UUID,
+		Title:              p.Title,
+		Content:            p.Content,
+		Author:             p.Author,
+		Slug:               p.Slug,
+		Tags:               p.Tags,
+		Published:          p.Published,
+		Deleted:            p.Deleted,
+		Version:            int64(p.Version),
+		Likes:              int64(p.Likes),
+		Views:              int64(p.Views),
+		WordCount:          int64(p.WordCount),
+		ReadingTimeMinutes: int64(p.ReadingTimeMinutes),
+		CreatedAt:          timestamppb.New(p.CreatedAt),
+		UpdatedAt:          timestamppb.New(p.UpdatedAt),
+	}
+	if p.DeletedAt != nil {
+		pb.DeletedAt = timestamppb.New(*p.DeletedAt)
+	}
+	if len(p.Reactions) > 0 {
+		pb.Reactions = make(map[string]int64, len(p.Reactions))
+		for emoji, n := range p.Reactions {
+			pb.Reactions[emoji] = int64(n)
+		}
+	}
+	for _, att := range p.Attachments {
+		pb.Attachments = append(pb.Attachments, &blogpb.Attachment{
+			Id:          att.ID,
+			Filename:    att.Filename,
+			Url:         att.URL,
+			Size:        att.Size,
+			ContentType: att.ContentType,
+			CreatedAt:   timestamppb.New(att.CreatedAt),
+		})
+	}
+	return pb
+}
+
+// postFromProto converts a post sent to CreatePost or UpdatePost. Like a JSON
+// body, it's only what the client sent, the handlers decide what's kept.
+func postFromProto(pb *blogpb.Post) Post {
+	return Post{
+		ID:        int(pb.Id),
+		Title:     pb.Title,
+		Content:   pb.Content,
+		Author:    pb.Author,
+		Slug:      pb.Slug,
+		Tags:      pb.Tags,
+		Published: pb.Published,
+		Version:   int(pb.Version),
+	}
+}
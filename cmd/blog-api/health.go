@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// health is the body of GET /health
+type health struct {
+	Status string `json:"status"`
+	Store  string `json:"store"`
+	Posts  int    `json:"posts"`
+	Uptime string `json:"uptime"`
+
+	// Error says why the status is degraded
+	Error string `json:"error,omitempty"`
+}
+
+// storeName is the kind of store, as /health reports it
+func storeName(s PostStore) string {
+	switch s.(type) {
+	case *SQLiteStore:
+		return "sqlite"
+	case *FileStore:
+		return "file"
+	case *MemStore:
+		return "memory"
+	}
+	return "unknown"
+}
+
+// getHealth is an overview for people: what's running and since when. It's
+// always a 200, an unreachable database makes it "degraded" instead of the
+// 503 probes get from /ready.
+func (a *api) getHealth(w http.ResponseWriter, r *http.Request) {
+	h := health{
+		Status: "ok",
+		Store:  storeName(a.store),
+		Uptime: time.Since(a.started).Round(time.Second).String(),
+	}
+
+	if p, ok := a.store.(pinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := p.Ping(ctx); err != nil {
+			slog.WarnContext(r.Context(), "store unhealthy", "err", err)
+			h.Status, h.Error = "degraded", err.Error()
+			writeJSON(w, r, http.StatusOK, h)
+			return
+		}
+	}
+
+	// Trashed posts are on their way out, drafts count
+	h.Posts = a.store.Count(r.Context(), func(p Post) bool { return !p.Deleted })
+	writeJSON(w, r, http.StatusOK, h)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// What ?highlight=true wraps each match of the search in
+const (
+	markOpen  = "<mark>"
+	markClose = "</mark>"
+)
+
+// highlightTerm is the ?q= to mark in the results, "" unless ?highlight=true
+// came with a search
+func highlightTerm(r *http.Request) string {
+	if r.URL.Query().Get("highlight") != "true" {
+		return ""
+	}
+	return r.URL.Query().Get("q")
+}
+
+// highlightPosts marks q in the title, excerpt and content of each post. The
+// posts are copies for one response, the stored ones never get marks.
+func highlightPosts(posts []Post, q string) {
+	if q == "" {
+		return
+	}
+	for i := range posts {
+		posts[i].Title = highlight(posts[i].Title, q)
+		posts[i].Excerpt = highlight(posts[i].Excerpt, q)
+		posts[i].Content = highlight(posts[i].Content, q)
+	}
+}
+
+// highlight wraps every occurrence of q in s in <mark>, ignoring case and
+// accents like the search does but keeping s's own. s is walked a rune at a
+// time so a mark never lands inside a multibyte character, and after a match
+// the search carries on past it, so marks never overlap. s is sanitized HTML:
+// tags are copied as they are and an entity is the one character it stands
+// for, so only the text a reader sees gets marked.
+func highlight(s, q string) string {
+	if q == "" {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '<' {
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			b.WriteString(s[i : i+end+1])
+			i += end + 1
+			continue
+		}
+		if n := matchPrefix(s[i:], q); n > 0 {
+			b.WriteString(markOpen)
+			b.WriteString(s[i : i+n])
+			b.WriteString(markClose)
+			i += n
+			continue
+		}
+		_, size := textRune(s[i:])
+		b.WriteString(s[i : i+size])
+		i += size
+	}
+	return b.String()
+}
+
+// matchPrefix is how many bytes at the start of s match q, 0 when s doesn't
+// start with it. Combining marks after a matched letter are part of the match,
+// a match never runs into a tag.
+func matchPrefix(s, q string) int {
+	n := 0
+	for _, want := range q {
+		if isMark(want) {
+			continue
+		}
+		// A mark belongs to the letter before it, which has to be matched first
+		if n > 0 {
+			n += skipMarks(s[n:])
+		}
+		if n == len(s) || s[n] == '<' {
+			return 0
+		}
+		r, size := textRune(s[n:])
+		if foldRune(r) != foldRune(want) {
+			return 0
+		}
+		n += size
+	}
+	if n == 0 {
+		return 0
+	}
+	return n + skipMarks(s[n:])
+}
+
+// skipMarks is the length of the combining marks s starts with
+func skipMarks(s string) int {
+	n := 0
+	for n < len(s) {
+		r, size := textRune(s[n:])
+		if !isMark(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// maxEntity is the longest entity textRune decodes, the named ones are shorter
+const maxEntity = len("&CounterClockwiseContourIntegral;")
+
+// textRune is the character s starts with and how many bytes it takes, an
+// entity like &amp; counts as the character it stands for
+func textRune(s string) (rune, int) {
+	if len(s) > 0 && s[0] == '&' {
+		if end := strings.IndexByte(s[:min(len(s), maxEntity)], ';'); end > 0 {
+			if text := html.UnescapeString(s[:end+1]); text != s[:end+1] {
+				if r, size := utf8.DecodeRuneInString(text); size == len(text) {
+					return r, end + 1
+				}
+			}
+		}
+	}
+	return utf8.DecodeRuneInString(s)
+}
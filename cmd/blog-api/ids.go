@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// postID turns the {id} of a URL into the post's numeric ID. With -uuid-ids the
+// URL must hold the post's UUID instead, so routes don't give away how many posts
+// there are. An unknown UUID maps to 0, which no post has, so handlers answer
+// with their usual 404.
+func (a *api) postID(ctx context.Context, s string) (int, error) {
+	if !a.cfg.uuidIDs {
+		return strconv.Atoi(s)
+	}
+
+	u, err := uuid.Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	post, _ := a.store.GetByUUID(ctx, u.String())
+	return post.ID, nil
+}
+
+// postPath is the URL path of a post, by the same key postID expects
+func (a *api) postPath(p Post) string {
+	if a.cfg.uuidIDs {
+		return "/posts/" + p.UUID
+	}
+	return "/posts/" + strconv.Itoa(p.ID)
+}
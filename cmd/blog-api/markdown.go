@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown turns post content into HTML, with GitHub flavoured tables, strikethrough and autolinks
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// htmlPolicy keeps the formatting Markdown produces and drops anything that
+// could run script, like <script>, event handlers and javascript: links.
+// goldmark already leaves out raw HTML, but authors aren't trusted, so the
+// output is sanitized too in case a renderer option or extension lets some in.
+var htmlPolicy = bluemonday.UGCPolicy()
+
+// renderMarkdown converts Markdown to HTML that's safe to put in a page
+func renderMarkdown(content string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(content), &buf); err != nil {
+		return "", err
+	}
+	return htmlPolicy.Sanitize(buf.String()), nil
+}
+
+// getPostHTML serves a post's content rendered from Markdown as an HTML fragment,
+// the JSON response keeps the raw Markdown
+func (a *api) getPostHTML(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	post, ok := a.store.Get(r.Context(), id)
+	if !ok || !visible(post, r) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	a.views.count(id)
+
+	html, err := renderMarkdown(plainText(post.Content))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rendering markdown", "id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error rendering post")
+		return
+	}
+	if fresh(w, r, postETag(post.Version, []byte(html)), post.UpdatedAt) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
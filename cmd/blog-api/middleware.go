@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var corsMethods = strings.Join([]string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}, ", ")
+
+var corsHeaders = strings.Join([]string{"Accept", "Authorization", "Content-Type", "If-Match", "X-API-Key", "X-Request-ID"}, ", ")
+
+// corsExposed are the response headers browser scripts may read
+var corsExposed = strings.Join([]string{"ETag", "Link", "X-Request-ID"}, ", ")
+
+// cors lets browsers on the allowed origins call the API, "*" allows any origin.
+// Preflight requests are answered here and never reach the handlers.
+func cors(origins []string) func(http.Handler) http.Handler {
+	allowAll := slices.Contains(origins, "*")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && (allowAll || slices.Contains(origins, origin)) {
+				if allowAll {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Add("Vary", "Origin")
+				}
+				w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+				w.Header().Set("Access-Control-Expose-Headers", corsExposed)
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitBody caps how much of a request body handlers can read, 0 means no limit.
+// Uploads and restores have their own limits, see uploadAttachment and restoreBackup.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n > 0 && r.Body != nil && !isUpload(r) && !isRestore(r) {
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestTimeout answers 503 when a handler takes longer than d, and cancels
+// the request's context so the handler can give up too. Paths in skip, like the
+// event stream, are meant to run for as long as the client stays.
+func requestTimeout(d time.Duration, skip ...string) func(http.Handler) http.Handler {
+	body, _ := json.Marshal(APIError{Error: "Request took too long", Code: codeTimeout, Status: http.StatusServiceUnavailable})
+	msg := string(body) + "\n"
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(skip, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rctx := chi.RouteContext(r.Context())
+			if rctx == nil {
+				http.TimeoutHandler(next, d, msg).ServeHTTP(w, r)
+				return
+			}
+
+			// chi puts its routing context back in a pool once the 503 is out,
+			// so a handler still running past it routes on a context of its own
+			own := chi.NewRouteContext()
+			own.Routes = rctx.Routes
+			finished := make(chan struct{})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(finished)
+				next.ServeHTTP(w, r)
+			})
+			http.TimeoutHandler(handler, d, msg).ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, own)))
+
+			// Done in time, so the metrics can see the route it matched
+			select {
+			case <-finished:
+				rctx.RoutePatterns = append(rctx.RoutePatterns, own.RoutePatterns...)
+			default:
+			}
+		})
+	}
+}
+
+// requireAPIKey makes POST, PUT, PATCH and DELETE requests prove they know the API key,
+// sent as "Authorization: Bearer <key>" or "X-API-Key: <key>". Reads stay public,
+// except for asking to see drafts, and so does leaving a comment.
+func requireAPIKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// GraphQL queries are reads sent as POST, its mutations check the key themselves
+			if ((!isWrite(r.Method) || isNewComment(r)) && !includeDrafts(r)) || isGraphQL(r) || checkAPIKey(w, r, key) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// requireAPIKeyForReads is requireAPIKey for every method, for routes
+// that aren't public even to read
+func requireAPIKeyForReads(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if checkAPIKey(w, r, key) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// checkAPIKey reports whether the request sent the key, writing a 401 when it didn't
+func checkAPIKey(w http.ResponseWriter, r *http.Request, key string) bool {
+	if problem := apiKeyProblem(r, key); problem != "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, problem)
+		return false
+	}
+	return true
+}
+
+// apiKeyProblem says what's wrong with the key the request sent, "" when it's the right one
+func apiKeyProblem(r *http.Request, key string) string {
+	got := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = bearer
+	}
+	if got == "" {
+		return "An API key is required"
+	}
+
+	// Constant time so the response time doesn't leak how much of the key matched
+	if subtle.ConstantTimeCompare([]byte(got), []byte(key)) != 1 {
+		return "Invalid API key"
+	}
+	return ""
+}
+
+// isWrite reports whether requests with this method change anything
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
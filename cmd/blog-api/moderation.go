@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// approveComment publishes a comment waiting for moderation. Approving one
+// that's already public changes nothing.
+func (a *api) approveComment(w http.ResponseWriter, r *http.Request) {
+	// Get IDs from URL parameters
+	postID, err := a.postID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "cid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid comment ID")
+		return
+	}
+
+	comment, ok := a.comments.Approve(postID, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Comment not found")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, comment)
+}
+
+// rejectComment deletes a comment, pending or not, along with its replies
+func (a *api) rejectComment(w http.ResponseWriter, r *http.Request) {
+	// Get IDs from URL parameters
+	postID, err := a.postID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "cid"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid comment ID")
+		return
+	}
+
+	if !a.comments.Delete(postID, id) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Comment not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPendingComments lists what's waiting for a moderator on every post, oldest first
+func (a *api) getPendingComments(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, a.comments.Pending())
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response formats getPosts and getPost can answer in
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+)
+
+// mediaFormats maps the media types we understand to a format, wildcards mean JSON
+var mediaFormats = map[string]string{
+	"application/json": formatJSON,
+	"application/*":    formatJSON,
+	"*/*":              formatJSON,
+	"application/xml":  formatXML,
+	"text/xml":         formatXML,
+}
+
+// negotiate picks the format the Accept header likes best, JSON when there's no header.
+// It writes a 406 and returns false when nothing we can produce is acceptable.
+func negotiate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	w.Header().Add("Vary", "Accept")
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		media, params, _ := strings.Cut(part, ";")
+		format, ok := mediaFormats[strings.ToLower(strings.TrimSpace(media))]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		// On a tie the earlier entry wins
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+
+	if best == "" {
+		writeError(w, http.StatusNotAcceptable, codeNotAcceptable, "Only application/json and application/xml are available")
+		return "", false
+	}
+	return best, true
+}
+
+// marshal encodes v in the given format, JSON ends in a newline like json.Encoder's output
+func marshal(format string, v any) ([]byte, error) {
+	if format == formatXML {
+		data, err := xml.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(xml.Header), data...), nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// writeEncoded sends a body encoded ahead of time with its length, which is
+// all a HEAD request gets: net/http drops the body itself
+func writeEncoded(w http.ResponseWriter, format string, body []byte) {
+	setContentType(w, format)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// setContentType replaces the default JSON content type for XML responses
+func setContentType(w http.ResponseWriter, format string) {
+	if format == formatXML {
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	}
+}
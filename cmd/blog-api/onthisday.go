@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// getOnThisDay lists the published posts created on today's month and day in
+// any year, the most recent year first. ?date=MM-DD asks about another day.
+func (a *api) getOnThisDay(w http.ResponseWriter, r *http.Request) {
+	day := time.Now().UTC()
+	if s := r.URL.Query().Get("date"); s != "" {
+		// Parsed into year 0, a leap year, so 02-29 is a valid day to ask about
+		var err error
+		if day, err = time.Parse("01-02", s); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidQuery, "Invalid date, use MM-DD")
+			return
+		}
+	}
+
+	posts := []Post{}
+	for _, post := range a.store.List(r.Context()) {
+		created := post.CreatedAt.UTC()
+		if post.Published && !post.Deleted && created.Month() == day.Month() && created.Day() == day.Day() {
+			posts = append(posts, post)
+		}
+	}
+	slices.SortStableFunc(posts, func(p, q Post) int { return q.CreatedAt.Compare(p.CreatedAt) })
+
+	withExcerpts(posts)
+	writeJSON(w, r, http.StatusOK, posts)
+}
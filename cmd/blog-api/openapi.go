@@ -0,0 +1,314 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The OpenAPI document is put together from the router and the Go types on
+// every request, so a new route or a new Post field shows up without anyone
+// editing a spec by hand. Only the summaries below are written out.
+
+// operation describes one route for the spec, schemas are named by component
+type operation struct {
+	summary  string
+	status   int    // success status
+	response string // schema of the success body, empty for none
+	body     string // schema of the request body, empty for none
+	list     bool   // the post list's query parameters apply
+}
+
+// operations documents every route, keyed by method and chi pattern.
+// TestOpenAPI fails when a route is missing here.
+var operations = map[string]operation{
+	"GET /feed.xml":               {summary: "RSS feed of the latest posts, only those with ?tag= when given", status: 200},
+	"GET /uploads/{name}":         {summary: "An attached image", status: 200},
+	"POST /graphql":               {summary: "Run a GraphQL query or mutation on the posts (only with -graphql)", status: 200, body: "GraphQL"},
+	"GET /posts.csv":              {summary: "Posts as CSV, same filters as the list", status: 200, list: true},
+	"GET /metrics":                {summary: "Prometheus metrics", status: 200},
+	"POST /admin/reset":           {summary: "Put the sample data back (only with -dev)", status: 204},
+	"GET /admin/comments/pending": {summary: "Comments waiting for moderation on every post, oldest first", status: 200, response: "[]Comment"},
+	"GET /admin/audit":            {summary: "Who changed which post, newest first (only with an API key, which it requires)", status: 200, response: "AuditPage"},
+	"GET /admin/backup":           {summary: "Every post and comment as one download (only with an API key, which it requires)", status: 200, response: "Backup"},
+	"POST /admin/restore":         {summary: "Replace every post and comment with a backup's, or nothing if it's invalid (only with an API key)", status: 204, body: "Backup"},
+	"GET /health":                 {summary: "Status, store kind, post count and uptime, degraded instead of failing when the store is unreachable", status: 200, response: "Health"},
+	"GET /ready":                  {summary: "Readiness probe, 503 while the store is unreachable or the server shuts down", status: 200},
+	"GET /openapi.json":           {summary: "This document", status: 200},
+	"GET /docs":                   {summary: "Swagger UI for this document", status: 200},
+
+	"GET /authors":              {summary: "Authors with their number of published posts", status: 200, response: "[]AuthorCount"},
+	"GET /authors/{name}/posts": {summary: "One author's posts", status: 200, response: "PostList", list: true},
+
+	"GET /posts":             {summary: "List posts", status: 200, response: "PostList", list: true},
+	"HEAD /posts":            {summary: "The headers GET /posts would send, without the body", status: 200, list: true},
+	"POST /posts":            {summary: "Create a post", status: 201, response: "Post", body: "Post"},
+	"POST /posts/batch":      {summary: "Create many posts, all or nothing", status: 201, response: "[]Post", body: "[]Post"},
+	"GET /posts/count":       {summary: "Count posts matching the list filters", status: 200, list: true},
+	"GET /posts/events":      {summary: "Live stream of post changes (Server-Sent Events)", status: 200},
+	"GET /posts/archive":     {summary: "Published posts counted by month, newest first", status: 200, response: "[]ArchiveMonth"},
+	"GET /posts/trash":       {summary: "Posts in the trash", status: 200, response: "[]Post"},
+	"GET /posts/trending":    {summary: "Most viewed posts", status: 200, response: "[]Post"},
+	"GET /posts/random":      {summary: "A published post picked at random, out of those with ?tag= and by ?author= when given", status: 200, response: "Post"},
+	"GET /posts/on-this-day": {summary: "Published posts created on today's month and day in any year, or on ?date=MM-DD, newest first", status: 200, response: "[]Post"},
+	"GET /posts/slug/{slug}": {summary: "Get a post by its slug", status: 200, response: "Post"},
+
+	"GET /posts/{id}":                          {summary: "Get a post", status: 200, response: "Post"},
+	"HEAD /posts/{id}":                         {summary: "The headers GET /posts/{id} would send, without the body", status: 200},
+	"GET /posts/{id}/html":                     {summary: "A post's content rendered as HTML", status: 200},
+	"GET /posts/{id}/related":                  {summary: "Published posts sharing the most tags with this one", status: 200, response: "[]Post"},
+	"PUT /posts/{id}":                          {summary: "Replace a post", status: 200, response: "Post", body: "Post"},
+	"PATCH /posts/{id}":                        {summary: "Change some fields of a post", status: 200, response: "Post", body: "PostPatch"},
+	"POST /posts/{id}/append":                  {summary: "Add a line to the end of a post's content", status: 200, response: "Post", body: "Append"},
+	"DELETE /posts/{id}":                       {summary: "Move a post to the trash, or with -unpublish-on-delete turn a published one into a draft (200 with the post)", status: 204},
+	"POST /posts/{id}/restore":                 {summary: "Take a post back out of the trash", status: 200, response: "Post"},
+	"DELETE /posts/{id}/permanent":             {summary: "Delete a post for good", status: 204},
+	"POST /posts/{id}/publish":                 {summary: "Publish a post", status: 200, response: "Post"},
+	"POST /posts/{id}/unpublish":               {summary: "Turn a post back into a draft", status: 200, response: "Post"},
+	"POST /posts/{id}/clone":                   {summary: "Copy a post into a new draft", status: 201, response: "Post"},
+	"GET /posts/{id}/attachments":              {summary: "Images attached to a post, oldest first", status: 200, response: "[]Attachment"},
+	"POST /posts/{id}/attachments":             {summary: "Attach an image, sent as the file field of a multipart form", status: 201, response: "Attachment"},
+	"POST /posts/{id}/like":                    {summary: "Like or unlike a post", status: 200},
+	"GET /posts/{id}/reactions":                {summary: "How many of each emoji a post got", status: 200, response: "Reactions"},
+	"POST /posts/{id}/reactions":               {summary: "React to a post with one of the allowed emoji", status: 200, response: "Reactions", body: "Reaction"},
+	"GET /posts/{id}/revisions":                {summary: "A post's earlier revisions", status: 200, response: "[]Revision"},
+	"POST /posts/{id}/revisions/{rev}/restore": {summary: "Roll a post back to a revision", status: 200, response: "Post"},
+	"GET /posts/{id}/diff":                     {summary: "Line diff between two revisions", status: 200},
+	"GET /posts/{id}/comments":                 {summary: "A post's approved comments", status: 200, response: "[]Comment"},
+	"POST /posts/{id}/comments":                {summary: "Comment on a post, hidden until approved", status: 201, response: "Comment", body: "Comment"},
+	"POST /posts/{id}/comments/{cid}/approve":  {summary: "Publish a comment waiting for moderation", status: 200, response: "Comment"},
+	"DELETE /posts/{id}/comments/{cid}":        {summary: "Reject or remove a comment and its replies", status: 204},
+}
+
+// schemaTypes are the components of the spec, built from the Go types
+var schemaTypes = map[string]reflect.Type{
+	"Post":         reflect.TypeOf(Post{}),
+	"PostPatch":    reflect.TypeOf(PostPatch{}),
+	"Append":       reflect.TypeOf(appendRequest{}),
+	"PostList":     reflect.TypeOf(PostList{}),
+	"Comment":      reflect.TypeOf(Comment{}),
+	"Revision":     reflect.TypeOf(Revision{}),
+	"AuthorCount":  reflect.TypeOf(authorCount{}),
+	"ArchiveMonth": reflect.TypeOf(archiveMonth{}),
+	"Attachment":   reflect.TypeOf(Attachment{}),
+	"Reaction":     reflect.TypeOf(reactionRequest{}),
+	"Reactions":    reflect.TypeOf(reactionCounts{}),
+	"AuditPage":    reflect.TypeOf(auditPage{}),
+	"Backup":       reflect.TypeOf(backup{}),
+	"Error":        reflect.TypeOf(APIError{}),
+	"Health":       reflect.TypeOf(health{}),
+	"GraphQL":      reflect.TypeOf(graphqlRequest{}),
+}
+
+// listParams are the query parameters of the post list
+var listParams = []parameter{
+	queryParam("q", "string", "Search the title and content"),
+	queryParam("fuzzy", "boolean", "Let the search words have typos"),
+	queryParam("highlight", "boolean", "Wrap what matched q in <mark> in titles and excerpts"),
+	queryParam("tag", "string", "Only posts with this tag, repeat for several"),
+	queryParam("author", "string", "Only posts by this author"),
+	queryParam("from", "string", "Only posts created on or after this date"),
+	queryParam("to", "string", "Only posts created on or before this date"),
+	queryParam("sort", "string", "Comma-separated keys out of id, title, author, created_at and score, each breaking ties in the one before, - in front for descending"),
+	queryParam("limit", "integer", "Posts per page, at most 100"),
+	queryParam("offset", "integer", "Posts to skip"),
+	queryParam("cursor", "string", "Continue after a page, from its next_cursor"),
+	queryParam("status", "string", "published (the default), draft or all, the last two need the API key when one is set"),
+	queryParam("include_drafts", "boolean", "Include unpublished posts, like status=all"),
+	queryParam("ids", "string", "Comma-separated IDs to fetch"),
+	queryParam("fields", "string", "Comma-separated fields to return"),
+	queryParam("embed", "string", "comment_count and/or comments, comma-separated, to add to each post"),
+}
+
+type openAPIDoc struct {
+	OpenAPI    string                             `json:"openapi"`
+	Info       map[string]string                  `json:"info"`
+	Paths      map[string]map[string]operationDoc `json:"paths"`
+	Components map[string]map[string]*schema      `json:"components"`
+}
+
+type operationDoc struct {
+	Summary     string              `json:"summary"`
+	Parameters  []parameter         `json:"parameters,omitempty"`
+	RequestBody *body               `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type body struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref        string             `json:"$ref,omitempty"`
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Nullable   bool               `json:"nullable,omitempty"`
+	Items      *schema            `json:"items,omitempty"`
+	Properties map[string]*schema `json:"properties,omitempty"`
+
+	AdditionalProperties *schema `json:"additionalProperties,omitempty"`
+}
+
+func queryParam(name, typ, description string) parameter {
+	return parameter{Name: name, In: "query", Description: description, Schema: &schema{Type: typ}}
+}
+
+// ref points at a component, "[]Post" is an array of them
+func ref(name string) *schema {
+	if item, ok := strings.CutPrefix(name, "[]"); ok {
+		return &schema{Type: "array", Items: ref(item)}
+	}
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// schemaOf describes a Go type the way encoding/json encodes it
+func schemaOf(t reflect.Type) *schema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaOf(t.Elem())
+		s.Nullable = true
+		return s
+	case reflect.Slice:
+		return &schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &schema{Type: "object", Properties: map[string]*schema{}}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name != "" && name != "-" {
+				s.Properties[name] = schemaOf(t.Field(i).Type)
+			}
+		}
+		return s
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &schema{Type: "integer"}
+	case reflect.Float64:
+		return &schema{Type: "number"}
+	}
+	return &schema{Type: "string"}
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// routePattern drops the trailing slash chi leaves on routes mounted at "/"
+func routePattern(route string) string {
+	if route != "/" {
+		route = strings.TrimSuffix(route, "/")
+	}
+	return route
+}
+
+// buildOpenAPI walks the router for its routes and documents each of them,
+// uuidIDs says post IDs in paths are UUIDs
+func buildOpenAPI(routes chi.Routes, uuidIDs bool) (openAPIDoc, error) {
+	doc := openAPIDoc{
+		OpenAPI:    "3.0.3",
+		Info:       map[string]string{"title": "Blog API", "version": "1.0.0"},
+		Paths:      map[string]map[string]operationDoc{},
+		Components: map[string]map[string]*schema{"schemas": {}},
+	}
+	for name, t := range schemaTypes {
+		doc.Components["schemas"][name] = schemaOf(t)
+	}
+
+	errorResponse := response{Description: "Error", Content: map[string]mediaType{"application/json": {Schema: ref("Error")}}}
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		route = routePattern(route)
+		op := operations[method+" "+route]
+
+		opDoc := operationDoc{Summary: op.summary, Responses: map[string]response{"default": errorResponse}}
+		for _, match := range pathParam.FindAllStringSubmatch(route, -1) {
+			s := &schema{Type: "string"}
+			switch {
+			case match[1] == "id" && uuidIDs:
+				s.Format = "uuid"
+			case match[1] == "id" || match[1] == "rev" || match[1] == "cid":
+				s.Type = "integer"
+			}
+			opDoc.Parameters = append(opDoc.Parameters, parameter{Name: match[1], In: "path", Required: true, Schema: s})
+		}
+		if op.list {
+			opDoc.Parameters = append(opDoc.Parameters, listParams...)
+		}
+		if op.body != "" {
+			opDoc.RequestBody = &body{Required: true, Content: map[string]mediaType{"application/json": {Schema: ref(op.body)}}}
+		}
+
+		ok := response{Description: http.StatusText(op.status)}
+		if op.response != "" {
+			ok.Content = map[string]mediaType{"application/json": {Schema: ref(op.response)}}
+		}
+		opDoc.Responses[strconv.Itoa(op.status)] = ok
+
+		if doc.Paths[route] == nil {
+			doc.Paths[route] = map[string]operationDoc{}
+		}
+		doc.Paths[route][strings.ToLower(method)] = opDoc
+		return nil
+	})
+	return doc, err
+}
+
+// getOpenAPI serves the spec for the routes of r
+func (a *api) getOpenAPI(routes chi.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, err := buildOpenAPI(routes, a.cfg.uuidIDs)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, "Error building the OpenAPI document")
+			return
+		}
+		writeJSON(w, r, http.StatusOK, doc)
+	}
+}
+
+// docsPage is Swagger UI pointed at /openapi.json, loaded from a CDN so
+// nothing extra has to be built or embedded
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Blog API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// getDocs serves Swagger UI for browsing and trying the API
+func getDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
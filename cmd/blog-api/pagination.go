@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor turns the last ID of a page into an opaque token, clients
+// shouldn't build or pick apart cursors themselves
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	id, err := strconv.Atoi(string(data))
+	if err != nil || id < 0 {
+		return 0, errInvalidCursor
+	}
+	return id, nil
+}
+
+// idOrder reports whether the sort key keeps the default ascending ID order,
+// the only order cursors can walk through
+func idOrder(sortKey string) bool {
+	return sortKey == "" || sortKey == "id"
+}
+
+// pageLinks builds the Link header for a list page: first, prev, next and last
+// with the request's other params (filters, sort) kept as they are. The URLs are
+// relative to the request, so cached lists don't depend on the Host header.
+// In cursor mode only first and next make sense.
+func pageLinks(r *http.Request, offset, limit, total int, nextCursor string) string {
+	link := func(rel string, set func(q url.Values)) string {
+		q := r.URL.Query()
+		q.Del("offset")
+		q.Del("cursor")
+		q.Set("limit", strconv.Itoa(limit))
+		set(q)
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.EscapedPath(), q.Encode(), rel)
+	}
+	atOffset := func(n int) func(url.Values) {
+		return func(q url.Values) { q.Set("offset", strconv.Itoa(n)) }
+	}
+
+	links := []string{link("first", atOffset(0))}
+	if r.URL.Query().Get("cursor") != "" {
+		if nextCursor != "" {
+			links = append(links, link("next", func(q url.Values) { q.Set("cursor", nextCursor) }))
+		}
+		return strings.Join(links, ", ")
+	}
+
+	if offset > 0 {
+		links = append(links, link("prev", atOffset(max(offset-limit, 0))))
+	}
+	if offset+limit < total {
+		links = append(links, link("next", atOffset(offset+limit)))
+	}
+	last := 0
+	if total > 0 {
+		last = (total - 1) / limit * limit
+	}
+	links = append(links, link("last", atOffset(last)))
+	return strings.Join(links, ", ")
+}
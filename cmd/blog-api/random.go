@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+)
+
+// getRandomPost answers with one published post picked at random, out of those
+// matching ?tag= and ?author= when they're given. math/rand/v2 seeds itself,
+// and nobody gains anything by predicting which post comes up.
+func (a *api) getRandomPost(w http.ResponseWriter, r *http.Request) {
+	filter := postFilter{
+		author: r.URL.Query().Get("author"),
+		tags:   r.URL.Query()["tag"],
+		status: statusPublished,
+	}
+	var matched []Post
+	for _, post := range a.store.List(r.Context()) {
+		if filter.match(post) {
+			matched = append(matched, post)
+		}
+	}
+	if len(matched) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, "No post matches")
+		return
+	}
+
+	post := matched[rand.IntN(len(matched))]
+	a.views.count(post.ID)
+
+	// Asking again is asking for another one
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, post)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// variationSelector asks for the emoji rather than the text form of a symbol like ❤
+const variationSelector = "\ufe0f"
+
+// allowedReactions are the only emoji posts can get, so clients can't fill the
+// map with arbitrary keys. Each is a single grapheme.
+var allowedReactions = []string{"👍", "👎", "❤" + variationSelector, "😂", "😮", "😢", "🎉", "🚀", "👀"}
+
+// reactionRequest is the body of POST /posts/{id}/reactions
+type reactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// reactionCounts is what both reaction endpoints answer with
+type reactionCounts struct {
+	Reactions map[string]int `json:"reactions"`
+}
+
+// allowedReaction returns the emoji as it's counted, so "❤" with or without
+// the variation selector is the same reaction
+func allowedReaction(emoji string) (string, bool) {
+	bare := strings.TrimSuffix(strings.TrimSpace(emoji), variationSelector)
+	for _, allowed := range allowedReactions {
+		if bare == strings.TrimSuffix(allowed, variationSelector) {
+			return allowed, true
+		}
+	}
+	return "", false
+}
+
+// getReactions returns how many of each emoji a post got
+func (a *api) getReactions(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	post, ok := a.findVisiblePost(r, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	counts := reactionCounts{Reactions: post.Reactions}
+	if counts.Reactions == nil {
+		counts.Reactions = map[string]int{}
+	}
+	writeJSON(w, r, http.StatusOK, counts)
+}
+
+// addReaction counts one more of an emoji and answers with all the counts.
+// Like likes, reacting isn't an edit and changes neither the version nor the revisions.
+func (a *api) addReaction(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	var req reactionRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	emoji, ok := allowedReaction(req.Emoji)
+	if !ok {
+		writeFieldErrors(w, []FieldError{{Field: "emoji", Message: "must be one of " + strings.Join(allowedReactions, " ")}})
+		return
+	}
+
+	if _, ok := a.findPost(r.Context(), id); !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	counts, err := a.store.AddReaction(r.Context(), id, emoji)
+	if errors.Is(err, ErrPostNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "adding reaction", "id", id, "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error adding reaction")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, reactionCounts{Reactions: counts})
+}
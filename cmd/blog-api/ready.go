@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// readyTimeout bounds how long /ready waits on the store
+const readyTimeout = 2 * time.Second
+
+// pinger is a store that depends on something that can go away, like a database
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// getReady is the readiness probe: 200 when the store answers, 503 when it
+// doesn't or the server is shutting down, so load balancers stop sending
+// traffic while in-flight requests drain. /up stays a plain liveness check.
+func (a *api) getReady(w http.ResponseWriter, r *http.Request) {
+	if a.shuttingDown.Load() {
+		writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Server is shutting down")
+		return
+	}
+
+	if p, ok := a.store.(pinger); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := p.Ping(ctx); err != nil {
+			slog.WarnContext(r.Context(), "store not ready", "err", err)
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, "Store is unavailable")
+			return
+		}
+	}
+
+	w.Write([]byte(`{"status":"ready"}` + "\n"))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultRelated is how many related posts come back without ?limit=
+const defaultRelated = 5
+
+// getRelated suggests other published posts sharing tags with this one, the
+// most shared tags first and the newest first among equals. A post without
+// tags, or whose tags nobody else uses, has no related posts.
+func (a *api) getRelated(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	source, ok := a.findVisiblePost(r, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	limit := queryInt(r, "limit", defaultRelated)
+	if limit == 0 {
+		limit = defaultRelated
+	}
+	limit = min(limit, maxLimit)
+
+	type scored struct {
+		post   Post
+		shared int
+	}
+	var matches []scored
+	for _, post := range a.store.List(r.Context()) {
+		if post.ID == source.ID || !post.Published || post.Deleted {
+			continue
+		}
+		// Tags match ignoring case, like ?tag= does
+		shared := 0
+		for _, tag := range post.Tags {
+			if hasTags(source, []string{tag}) {
+				shared++
+			}
+		}
+		if shared > 0 {
+			matches = append(matches, scored{post, shared})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].shared != matches[j].shared {
+			return matches[i].shared > matches[j].shared
+		}
+		return matches[i].post.CreatedAt.After(matches[j].post.CreatedAt)
+	})
+
+	posts := []Post{}
+	for _, m := range matches[:min(len(matches), limit)] {
+		posts = append(posts, m.post)
+	}
+	withExcerpts(posts)
+	writeJSON(w, r, http.StatusOK, posts)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// APIError is the body of every error response, so clients always get JSON back
+type APIError struct {
+	Error  string `json:"error"`
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+
+	// Errors lists each invalid field of a 422
+	Errors []FieldError `json:"errors,omitempty"`
+
+	// ExistingID is the post a duplicate title clashes with
+	ExistingID int `json:"existing_id,omitempty"`
+}
+
+// Machine-readable error codes
+const (
+	codeInvalidID     = "invalid_id"
+	codeInvalidJSON   = "invalid_json"
+	codeInvalidFields = "invalid_fields"
+	codeInvalidQuery  = "invalid_query"
+	codeNotFound      = "not_found"
+	codeNotAcceptable = "not_acceptable"
+	codeUnsupported   = "unsupported_media_type"
+	codeNoMethod      = "method_not_allowed"
+	codeConflict      = "conflict"
+	codeTitleTaken    = "title_taken"
+	codeInvalidHeader = "invalid_header"
+	codeBodyTooLarge  = "body_too_large"
+	codeRateLimited   = "rate_limited"
+	codeUnauthorized  = "unauthorized"
+	codeUnavailable   = "unavailable"
+	codeTimeout       = "timeout"
+	codeInternal      = "internal_error"
+
+	codeVersionMismatch = "version_mismatch"
+)
+
+// writeError sends a JSON error with the given status
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorBody(w, APIError{Error: message, Code: code, Status: status})
+}
+
+// writeErrorBody sends e with its status
+func writeErrorBody(w http.ResponseWriter, e APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		slog.Error("encoding error response", "status", e.Status, "err", err)
+	}
+}
+
+// writeJSON sends v with the given status, indented for people when the
+// request asks for it with ?pretty=true. Every handler answers through it.
+// v is encoded before anything is written, so a value that can't be encoded
+// is a clean 500 instead of a 201 with half a body.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if pretty(r) {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		slog.ErrorContext(r.Context(), "encoding response", "status", status, "err", err)
+		// The ETag and Location were set for the body that couldn't be sent
+		w.Header().Del("ETag")
+		w.Header().Del("Location")
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error encoding response")
+		return
+	}
+
+	w.WriteHeader(status)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		// The status is out, all that's left is to say the client went away
+		slog.DebugContext(r.Context(), "writing response", "err", err)
+	}
+}
+
+// pretty reports whether the client asked for indented JSON. Compact is the
+// default since it's smaller and nobody reads most responses.
+func pretty(r *http.Request) bool {
+	on, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	return on
+}
+
+// indentJSON is writeJSON's indenting for a body that was encoded ahead of
+// time, like the cached lists. XML bodies are left alone.
+func indentJSON(r *http.Request, format string, body []byte) []byte {
+	if format != formatJSON || !pretty(r) {
+		return body
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return body
+	}
+	return buf.Bytes()
+}
+
+// decodeJSON reads the request body into v, writing the error response and
+// returning false when the body isn't usable. Unknown fields are rejected so
+// a typo like "titel" is reported instead of silently dropped.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge,
+			fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+		return false
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON: "+err.Error())
+	return false
+}
+
+// writeCreateError maps the errors PostStore.Create can return to a response
+func writeCreateError(w http.ResponseWriter, r *http.Request, err error) {
+	var titleErr *TitleTakenError
+	switch {
+	case errors.Is(err, ErrPostExists):
+		writeError(w, http.StatusConflict, codeConflict, "A post with this ID already exists")
+	case errors.As(err, &titleErr):
+		writeErrorBody(w, APIError{
+			Error:      "A post with this title already exists",
+			Code:       codeTitleTaken,
+			Status:     http.StatusConflict,
+			ExistingID: titleErr.ID,
+		})
+	default:
+		slog.ErrorContext(r.Context(), "creating post", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error creating post")
+	}
+}
+
+// writeUpdateError maps the errors PostStore.Update can return to a response
+func writeUpdateError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrPostNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+	case errors.Is(err, ErrVersionMismatch):
+		writeError(w, http.StatusPreconditionFailed, codeVersionMismatch, "Post was changed by someone else, fetch it and try again")
+	default:
+		slog.ErrorContext(r.Context(), "updating post", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error updating post")
+	}
+}
+
+// notFound replaces chi's plain text 404 for unknown paths
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+}
+
+// routeMethods are the methods we check a path against to fill in Allow
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// methodTable knows which methods each path has. Match on the router itself
+// says yes to every method at a sub-router's root like /posts, so the routes
+// are copied into one flat mux, on first use when they're all registered.
+type methodTable struct {
+	routes chi.Routes
+	once   sync.Once
+	flat   *chi.Mux
+}
+
+// methods lists the methods path has, in routeMethods order
+func (t *methodTable) methods(path string) []string {
+	t.once.Do(func() {
+		t.flat = chi.NewMux()
+		noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+		chi.Walk(t.routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			// Sub-router roots answer with and without the slash
+			t.flat.Method(method, route, noop)
+			t.flat.Method(method, routePattern(route), noop)
+			return nil
+		})
+	})
+
+	var methods []string
+	for _, method := range routeMethods {
+		if t.flat.Match(chi.NewRouteContext(), method, path) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// methodNotAllowed replaces chi's empty 405. A custom handler loses the Allow
+// header chi would set, so it fills it in itself.
+func methodNotAllowed(table *methodTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range table.methods(r.URL.Path) {
+			w.Header().Add("Allow", method)
+		}
+		writeError(w, http.StatusMethodNotAllowed, codeNoMethod, "Method not allowed")
+	}
+}
+
+// options answers OPTIONS on any route with the methods it has in Allow.
+// CORS preflights are answered by cors before this, unknown paths still 404.
+func options(table *methodTable) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			methods := table.methods(r.URL.Path)
+			if len(methods) == 0 {
+				notFound(w, r)
+				return
+			}
+			w.Header().Set("Allow", strings.Join(append(methods, http.MethodOptions), ", "))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxRevisions is how much history is kept per post, older revisions are dropped
+const maxRevisions = 20
+
+// Revision is a post as it was before an edit
+type Revision struct {
+	Number    int       `json:"number"`
+	PostID    int       `json:"post_id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RevisionStore keeps the edit history of posts, separate from the posts themselves
+type RevisionStore interface {
+	List(postID int) []Revision
+	Get(postID, number int) (Revision, bool)
+	Add(old Post) Revision
+	Delete(postID int)
+}
+
+// MemRevisionStore keeps the last maxRevisions revisions of each post in memory.
+// Numbers keep counting up per post, so a number always means the same revision.
+type MemRevisionStore struct {
+	mu        sync.RWMutex
+	revisions map[int][]Revision
+	next      map[int]int
+}
+
+func NewMemRevisionStore() *MemRevisionStore {
+	return &MemRevisionStore{revisions: map[int][]Revision{}, next: map[int]int{}}
+}
+
+// List returns a copy of the post's revisions, oldest first and never nil
+func (s *MemRevisionStore) List(postID int) []Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Revision{}, s.revisions[postID]...)
+}
+
+func (s *MemRevisionStore) Get(postID, number int) (Revision, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, rev := range s.revisions[postID] {
+		if rev.Number == number {
+			return rev, true
+		}
+	}
+	return Revision{}, false
+}
+
+// Add snapshots old, dropping the oldest revision once there are too many
+func (s *MemRevisionStore) Add(old Post) Revision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next[old.ID]++
+	rev := Revision{
+		Number:    s.next[old.ID],
+		PostID:    old.ID,
+		Title:     old.Title,
+		Content:   old.Content,
+		Author:    old.Author,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	revs := append(s.revisions[old.ID], rev)
+	if len(revs) > maxRevisions {
+		revs = revs[len(revs)-maxRevisions:]
+	}
+	s.revisions[old.ID] = revs
+	return rev
+}
+
+// Delete forgets a post's history, so a new post reusing the ID starts fresh
+func (s *MemRevisionStore) Delete(postID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.revisions, postID)
+	delete(s.next, postID)
+}
+
+func (s *MemRevisionStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revisions = map[int][]Revision{}
+	s.next = map[int]int{}
+	return nil
+}
+
+// revisionDiff is what changed between two versions of a post
+type revisionDiff struct {
+	From          string     `json:"from"`
+	To            string     `json:"to"`
+	TitleChanged  bool       `json:"title_changed"`
+	AuthorChanged bool       `json:"author_changed"`
+	Content       []diffLine `json:"content"`
+}
+
+// saveRevision records old after it was replaced by updated, edits that didn't
+// touch the title, content or author (like publishing) aren't worth a revision
+func (a *api) saveRevision(old, updated Post) {
+	if old.Title == updated.Title && old.Content == updated.Content && old.Author == updated.Author {
+		return
+	}
+	a.revisions.Add(old)
+}
+
+func (a *api) getRevisions(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	if _, ok := a.findVisiblePost(r, id); !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, a.revisions.List(id))
+}
+
+// restoreRevision puts an old revision's title, content and author back. That's
+// an edit like any other, so what it replaces becomes a revision too.
+func (a *api) restoreRevision(w http.ResponseWriter, r *http.Request) {
+	// Get IDs from URL parameters
+	id, err := a.postID(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+	number, err := strconv.Atoi(chi.URLParam(r, "rev"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid revision number")
+		return
+	}
+
+	current, ok := a.findPost(r.Context(), id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	rev, ok := a.revisions.Get(id, number)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Revision not found")
+		return
+	}
+
+	post := current
+	if rev.Title != post.Title {
+		post.Slug = "" // has the store regenerate it
+	}
+	post.Title, post.Content, post.Author = rev.Title, rev.Content, rev.Author
+
+	post, err = a.store.Update(r.Context(), id, post)
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	a.saveRevision(current, post)
+	a.emit(r, eventUpdated, post)
+
+	setETag(w, post)
+	writeJSON(w, r, http.StatusOK, post)
+}
+
+// diffRevisions compares two versions of a post, ?from= and ?to= are revision
+// numbers or "current" for the post as it is now
+func (a *api) diffRevisions(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	current, ok := a.findVisiblePost(r, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+
+	q := r.URL.Query()
+	from, ok := a.revisionAt(current, q.Get("from"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidQuery, `The from parameter must be a revision number or "current"`)
+		return
+	}
+	to, ok := a.revisionAt(current, q.Get("to"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidQuery, `The to parameter must be a revision number or "current"`)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, revisionDiff{
+		From:          q.Get("from"),
+		To:            q.Get("to"),
+		TitleChanged:  from.Title != to.Title,
+		AuthorChanged: from.Author != to.Author,
+		Content:       diffLines(from.Content, to.Content),
+	})
+}
+
+// revisionAt looks up the version of post that ref names, a stored revision
+// number or "current"
+func (a *api) revisionAt(post Post, ref string) (Revision, bool) {
+	if ref == "current" {
+		return Revision{PostID: post.ID, Title: post.Title, Content: post.Content, Author: post.Author, CreatedAt: post.UpdatedAt}, true
+	}
+	number, err := strconv.Atoi(ref)
+	if err != nil {
+		return Revision{}, false
+	}
+	return a.revisions.Get(post.ID, number)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"html"
+)
+
+// sanitizer cleans up text from clients before it's stored, so markup in a
+// post can't run script in the browsers it's shown in
+type sanitizer func(string) string
+
+// plainText turns the entities sanitizing leaves in stored text back into the
+// characters that were sent, for what isn't HTML: slugs, searches, feed
+// titles, CSV and Markdown, which escapes as it renders.
+func plainText(s string) string {
+	return html.UnescapeString(s)
+}
+
+// newSanitizer picks how posts are cleaned: "escape" turns every <, >, & and
+// quote into an entity, "basic" keeps simple formatting like <b> and <a href>
+// and drops the rest, including scripts and event handlers.
+func newSanitizer(mode string) (sanitizer, error) {
+	switch mode {
+	case "escape":
+		// Unescaping first means text that went through already (like a
+		// post fetched and sent back with PUT) isn't escaped twice
+		return func(s string) string { return html.EscapeString(html.UnescapeString(s)) }, nil
+	case "basic":
+		return htmlPolicy.Sanitize, nil
+	}
+	return nil, fmt.Errorf("unknown sanitize mode %q, want escape or basic", mode)
+}
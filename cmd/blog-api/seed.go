@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// seeder is a store that can start over from a given set of posts
+type seeder interface {
+	Seed(ctx context.Context, posts []Post) error
+}
+
+// loadSeed reads a JSON array of posts for -seed. Every post goes through the
+// same checks as one sent to POST /posts, errors name the post they're about.
+func (a *api) loadSeed(path string) ([]Post, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []json.RawMessage
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	posts := make([]Post, len(records))
+	for i, record := range records {
+		dec := json.NewDecoder(bytes.NewReader(record))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&posts[i]); err != nil {
+			return nil, fmt.Errorf("%s: post %d: %w", path, i, err)
+		}
+		if errs := a.prepareNewPost(&posts[i]); len(errs) > 0 {
+			var msgs []string
+			for _, e := range errs {
+				msgs = append(msgs, e.Field+" "+e.Message)
+			}
+			return nil, fmt.Errorf("%s: post %d %s: %s", path, i, record, strings.Join(msgs, ", "))
+		}
+	}
+	return posts, nil
+}
+
+// seedStore replaces the store's posts with the -seed file's, and remembers
+// them so POST /admin/reset goes back to them instead of the sample data
+func (a *api) seedStore(path string) error {
+	s, ok := a.store.(seeder)
+	if !ok {
+		return errors.New("this store can't be seeded")
+	}
+	posts, err := a.loadSeed(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Seed(context.Background(), posts); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	a.seed = posts
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// slugify turns a title into a URL-friendly slug: lowercase, words joined
+// with hyphens, anything that isn't a letter or digit dropped
+func slugify(title string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(plainText(title)) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+			hyphen = false
+		case c == ' ' || c == '-' || c == '_':
+			// Collapse runs of separators into a single hyphen
+			if b.Len() > 0 && !hyphen {
+				b.WriteByte('-')
+				hyphen = true
+			}
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		return "post"
+	}
+	return slug
+}
+
+// uniqueSlug appends -2, -3, ... to slug until taken reports it as free
+func uniqueSlug(slug string, taken func(string) bool) string {
+	candidate := slug
+	for n := 2; taken(candidate); n++ {
+		candidate = slug + "-" + strconv.Itoa(n)
+	}
+	return candidate
+}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// SQLiteStore keeps posts in a SQLite database, the database hands out the IDs
+type SQLiteStore struct {
+	db *sql.DB
+
+	// uniqueTitles makes Create refuse a title a post outside the trash already has
+	uniqueTitles bool
+}
+
+// migrations run in order, PRAGMA user_version remembers how many already ran
+var migrations = []string{
+	`CREATE TABLE posts (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		title      TEXT NOT NULL,
+		content    TEXT NOT NULL,
+		author     TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	)`,
+	`ALTER TABLE posts ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE posts ADD COLUMN slug TEXT NOT NULL DEFAULT ''`,
+	// Rows from before drafts existed were all public
+	`ALTER TABLE posts ADD COLUMN published INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE posts ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	// NULL for posts that aren't in the trash
+	`ALTER TABLE posts ADD COLUMN deleted_at TEXT`,
+	`ALTER TABLE posts ADD COLUMN likes INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE posts ADD COLUMN views INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE posts ADD COLUMN uuid TEXT NOT NULL DEFAULT ''`,
+	// Random version 4 UUIDs for the rows already there
+	`UPDATE posts SET uuid = lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' ||
+		substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))`,
+	`CREATE UNIQUE INDEX posts_uuid ON posts (uuid)`,
+	// A JSON object of emoji to count
+	`ALTER TABLE posts ADD COLUMN reactions TEXT NOT NULL DEFAULT '{}'`,
+	// A JSON array of attachment metadata, the files are on disk
+	`ALTER TABLE posts ADD COLUMN attachments TEXT NOT NULL DEFAULT '[]'`,
+}
+
+const selectPost = `SELECT id, uuid, title, content, author, slug, tags, published, version, likes, views, reactions, attachments, created_at, updated_at, deleted_at FROM posts`
+
+// NewSQLiteStore opens the database, creating the posts table and the sample data on first run
+func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, one connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+	s := &SQLiteStore{db: db}
+
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate brings the schema up to date, seeding the sample data when the database is brand new
+func (s *SQLiteStore) migrate() error {
+	var version, tables int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	err := s.db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'posts'`).Scan(&tables)
+	if err != nil {
+		return err
+	}
+	// Databases from before migrations were tracked already have the first one
+	if version == 0 && tables == 1 {
+		version = 1
+	}
+	fresh := tables == 0
+
+	for i := version; i < len(migrations); i++ {
+		if _, err := s.db.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := s.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			return err
+		}
+	}
+
+	if fresh {
+		for _, post := range samplePosts() {
+			if _, err := insertPost(context.Background(), s.db, post); err != nil {
+				return err
+			}
+		}
+	}
+	return s.backfillSlugs()
+}
+
+// backfillSlugs gives rows written before slugs existed one
+func (s *SQLiteStore) backfillSlugs() error {
+	rows, err := s.db.Query(`SELECT id, title FROM posts WHERE slug = '' ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var missing []Post
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.ID, &post.Title); err != nil {
+			rows.Close()
+			return err
+		}
+		missing = append(missing, post)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, post := range missing {
+		if _, err := s.db.Exec(`UPDATE posts SET slug = ? WHERE id = ?`, slugFor(context.Background(), s.db, post), post.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common. With a single connection,
+// anything run during a transaction has to go through the transaction.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// slugFor returns a slug for p that no other row uses
+func slugFor(ctx context.Context, q queryer, p Post) string {
+	slug := p.Slug
+	if slug == "" {
+		slug = slugify(p.Title)
+	}
+	return uniqueSlug(slug, func(slug string) bool {
+		var n int
+		err := q.QueryRowContext(ctx, `SELECT count(*) FROM posts WHERE slug = ? AND id != ?`, slug, p.ID).Scan(&n)
+		return err == nil && n > 0
+	})
+}
+
+// Reset deletes every row and inserts the sample posts again, numbering restarts at 1
+func (s *SQLiteStore) Reset() error {
+	return s.Seed(context.Background(), samplePosts())
+}
+
+// Seed replaces every row with the given posts in one transaction, numbering
+// restarts at 1. Posts keep their IDs and times when they have them.
+func (s *SQLiteStore) Seed(ctx context.Context, posts []Post) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM posts`); err != nil {
+		return err
+	}
+	// AUTOINCREMENT remembers the highest ID here
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sqlite_sequence WHERE name = 'posts'`); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for i, post := range posts {
+		post.UUID = uuid.NewString()
+		post.Slug = slugFor(ctx, tx, post)
+		post.Version = 1
+		post.Likes, post.Views, post.Reactions = 0, 0, nil
+		post.Attachments = nil
+		post.CreatedAt, post.UpdatedAt = seedTimes(post, now)
+		if _, err := insertPost(ctx, tx, post); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return tx.Commit()
+}
+
+// Restore replaces every post with the given ones exactly as they are, in one
+// transaction so a failure leaves the old posts in place
+func (s *SQLiteStore) Restore(ctx context.Context, posts []Post) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM posts`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sqlite_sequence WHERE name = 'posts'`); err != nil {
+		return err
+	}
+	for i, post := range posts {
+		if post.Slug == "" {
+			post.Slug = slugFor(ctx, tx, post)
+		}
+		if _, err := insertPost(ctx, tx, post); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks the database can still be reached
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Most PostStore methods have no error return, so database errors
+// are logged and reported the same way as a missing post
+
+func (s *SQLiteStore) List(ctx context.Context) []Post {
+	rows, err := s.db.QueryContext(ctx, selectPost+` ORDER BY id`)
+	if err != nil {
+		slog.Error("listing posts", "err", err)
+		return []Post{}
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			slog.Error("listing posts", "err", err)
+			return []Post{}
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("listing posts", "err", err)
+		return []Post{}
+	}
+	return posts
+}
+
+// Count streams the rows through match instead of building a slice
+func (s *SQLiteStore) Count(ctx context.Context, match func(Post) bool) int {
+	rows, err := s.db.QueryContext(ctx, selectPost)
+	if err != nil {
+		slog.Error("counting posts", "err", err)
+		return 0
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			slog.Error("counting posts", "err", err)
+			return 0
+		}
+		if match(post) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id int) (Post, bool) {
+	post, err := scanPost(s.db.QueryRowContext(ctx, selectPost+` WHERE id = ?`, id))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("getting post", "id", id, "err", err)
+		}
+		return Post{}, false
+	}
+	return post, true
+}
+
+func (s *SQLiteStore) GetBySlug(ctx context.Context, slug string) (Post, bool) {
+	post, err := scanPost(s.db.QueryRowContext(ctx, selectPost+` WHERE slug = ?`, slug))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("getting post", "slug", slug, "err", err)
+		}
+		return Post{}, false
+	}
+	return post, true
+}
+
+func (s *SQLiteStore) GetByUUID(ctx context.Context, uuid string) (Post, bool) {
+	post, err := scanPost(s.db.QueryRowContext(ctx, selectPost+` WHERE uuid = ?`, uuid))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("getting post", "uuid", uuid, "err", err)
+		}
+		return Post{}, false
+	}
+	return post, true
+}
+
+func (s *SQLiteStore) Create(ctx context.Context, p Post) (Post, error) {
+	p.UUID = uuid.NewString()
+	p.Slug = slugFor(ctx, s.db, p)
+	setReadingStats(&p)
+	p.Version = 1
+	p.Likes, p.Views, p.Reactions = 0, 0, nil
+	p.Attachments = nil
+	p.CreatedAt = time.Now().UTC()
+	p.UpdatedAt = p.CreatedAt
+
+	if !s.uniqueTitles {
+		id, err := insertPost(ctx, s.db, p)
+		if err != nil {
+			return Post{}, err
+		}
+		p.ID = id
+		return p, nil
+	}
+
+	// The check and the insert share a transaction, so two copies can't both get in
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Post{}, err
+	}
+	defer tx.Rollback()
+
+	if id, err := titleOwner(ctx, tx, p.Title); err != nil {
+		return Post{}, err
+	} else if id != 0 {
+		return Post{}, &TitleTakenError{ID: id}
+	}
+	id, err := insertPost(ctx, tx, p)
+	if err != nil {
+		return Post{}, err
+	}
+	p.ID = id
+	return p, tx.Commit()
+}
+
+// titleOwner returns the ID of a post outside the trash with the same title, 0 when there's none.
+// SQLite's lower() only knows ASCII, so titles are compared in Go.
+func titleOwner(ctx context.Context, tx *sql.Tx, title string) (int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, title FROM posts WHERE deleted_at IS NULL`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var existing string
+		if err := rows.Scan(&id, &existing); err != nil {
+			return 0, err
+		}
+		if sameTitle(existing, title) {
+			return id, nil
+		}
+	}
+	return 0, rows.Err()
+}
+
+// CreateMany inserts the posts in one transaction, so a conflict rolls back the whole batch
+func (s *SQLiteStore) CreateMany(ctx context.Context, posts []Post) ([]Post, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	created := make([]Post, len(posts))
+	for i, p := range posts {
+		p.UUID = uuid.NewString()
+		p.Slug = slugFor(ctx, tx, p)
+		setReadingStats(&p)
+		p.Version = 1
+		p.Likes, p.Views, p.Reactions = 0, 0, nil
+		p.Attachments = nil
+		p.CreatedAt = now
+		p.UpdatedAt = now
+
+		id, err := insertPost(ctx, tx, p)
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		p.ID = id
+		created[i] = p
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// insertPost lets the database pick the ID unless the post has one, and returns it.
+// A taken ID inserts nothing, so no row comes back.
+func insertPost(ctx context.Context, q queryer, p Post) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx,
+		`INSERT INTO posts (id, uuid, title, content, author, slug, tags, published, version, likes, views, reactions, attachments, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING RETURNING id`,
+		sql.NullInt64{Int64: int64(p.ID), Valid: p.ID != 0}, p.UUID,
+		p.Title, p.Content, p.Author, p.Slug, formatTags(p.Tags), p.Published, max(p.Version, 1), p.Likes, p.Views, formatReactions(p.Reactions), formatAttachments(p.Attachments), formatTime(p.CreatedAt), formatTime(p.UpdatedAt), formatDeletedAt(p),
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrPostExists
+	}
+	return id, err
+}
+
+// Update replaces a post, the ID and creation time are kept from the original.
+// The WHERE on version makes the check and the write a single atomic step.
+func (s *SQLiteStore) Update(ctx context.Context, id int, p Post) (Post, error) {
+	p.ID = id
+	p.Slug = slugFor(ctx, s.db, p)
+	p.UpdatedAt = time.Now().UTC()
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE posts SET title = ?, content = ?, author = ?, slug = ?, tags = ?, published = ?, version = version + 1, updated_at = ?, deleted_at = ?
+		WHERE id = ? AND version = ?`,
+		p.Title, p.Content, p.Author, p.Slug, formatTags(p.Tags), p.Published, formatTime(p.UpdatedAt), formatDeletedAt(p), id, p.Version,
+	)
+	if err != nil {
+		return Post{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Post{}, err
+	} else if n == 0 {
+		// Either the post is gone or its version moved on
+		if _, ok := s.Get(ctx, id); ok {
+			return Post{}, ErrVersionMismatch
+		}
+		return Post{}, ErrPostNotFound
+	}
+
+	post, ok := s.Get(ctx, id)
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	return post, nil
+}
+
+// AppendContent does the concatenation in SQL, so concurrent appends can't overwrite each other
+func (s *SQLiteStore) AppendContent(ctx context.Context, id int, content string) (Post, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE posts SET content = CASE WHEN content = '' THEN ?1 ELSE content || char(10) || ?1 END,
+		version = version + 1, updated_at = ?2
+		WHERE id = ?3 AND deleted_at IS NULL`,
+		content, formatTime(time.Now()), id,
+	)
+	if err != nil {
+		return Post{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Post{}, err
+	} else if n == 0 {
+		return Post{}, ErrPostNotFound
+	}
+
+	post, ok := s.Get(ctx, id)
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	return post, nil
+}
+
+// AddAttachment appends to the JSON array in SQL, so concurrent uploads can't overwrite each other
+func (s *SQLiteStore) AddAttachment(ctx context.Context, id int, att Attachment) (Post, error) {
+	data, err := json.Marshal(att)
+	if err != nil {
+		return Post{}, err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE posts SET attachments = json_insert(attachments, '$[#]', json(?)), version = version + 1, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		string(data), formatTime(time.Now()), id,
+	)
+	if err != nil {
+		return Post{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Post{}, err
+	} else if n == 0 {
+		return Post{}, ErrPostNotFound
+	}
+
+	post, ok := s.Get(ctx, id)
+	if !ok {
+		return Post{}, ErrPostNotFound
+	}
+	return post, nil
+}
+
+// AddLikes does the increment in SQL, so concurrent likes can't overwrite each other
+func (s *SQLiteStore) AddLikes(ctx context.Context, id, delta int) (int, error) {
+	var likes int
+	err := s.db.QueryRowContext(ctx, `UPDATE posts SET likes = max(likes + ?, 0) WHERE id = ? RETURNING likes`, delta, id).Scan(&likes)
+	if err == sql.ErrNoRows {
+		return 0, ErrPostNotFound
+	}
+	return likes, err
+}
+
+// AddReaction does the increment in SQL like AddLikes. Only allowed emoji get
+// here, so the key can't break out of the JSON path.
+func (s *SQLiteStore) AddReaction(ctx context.Context, id int, emoji string) (map[string]int, error) {
+	path := `$."` + emoji + `"`
+	var reactions string
+	err := s.db.QueryRowContext(ctx,
+		`UPDATE posts SET reactions = json_set(reactions, ?, coalesce(json_extract(reactions, ?), 0) + 1) WHERE id = ? RETURNING reactions`,
+		path, path, id,
+	).Scan(&reactions)
+	if err == sql.ErrNoRows {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseReactions(reactions)
+}
+
+func (s *SQLiteStore) AddViews(ctx context.Context, id, n int) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE posts SET views = views + ? WHERE id = ?`, n, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPostNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, id int) bool {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM posts WHERE id = ?`, id)
+	return affected(res, err, "deleting post", id)
+}
+
+// affected reports whether a statement touched a row, logging any error
+func affected(res sql.Result, err error, action string, id int) bool {
+	if err != nil {
+		slog.Error(action, "id", id, "err", err)
+		return false
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		slog.Error(action, "id", id, "err", err)
+		return false
+	}
+	return n > 0
+}
+
+// scanner is what *sql.Row and *sql.Rows have in common
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPost(row scanner) (Post, error) {
+	var post Post
+	var tags, reactions, attachments, createdAt, updatedAt string
+	var deletedAt sql.NullString
+	if err := row.Scan(&post.ID, &post.UUID, &post.Title, &post.Content, &post.Author, &post.Slug, &tags, &post.Published, &post.Version, &post.Likes, &post.Views, &reactions, &attachments, &createdAt, &updatedAt, &deletedAt); err != nil {
+		return Post{}, err
+	}
+
+	if err := json.Unmarshal([]byte(tags), &post.Tags); err != nil {
+		return Post{}, err
+	}
+	var err error
+	if post.Reactions, err = parseReactions(reactions); err != nil {
+		return Post{}, err
+	}
+	if err := json.Unmarshal([]byte(attachments), &post.Attachments); err != nil {
+		return Post{}, err
+	}
+	if len(post.Attachments) == 0 {
+		post.Attachments = nil
+	}
+	if post.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return Post{}, err
+	}
+	if post.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt); err != nil {
+		return Post{}, err
+	}
+	if deletedAt.Valid {
+		t, err := time.Parse(time.RFC3339Nano, deletedAt.String)
+		if err != nil {
+			return Post{}, err
+		}
+		post.Deleted, post.DeletedAt = true, &t
+	}
+	setReadingStats(&post)
+	return post, nil
+}
+
+// Tags are stored as a JSON array
+func formatTags(tags []string) string {
+	data, _ := json.Marshal(normalizeTags(tags))
+	return string(data)
+}
+
+// Reactions are stored as a JSON object
+func formatReactions(reactions map[string]int) string {
+	if len(reactions) == 0 {
+		return "{}"
+	}
+	data, _ := json.Marshal(reactions)
+	return string(data)
+}
+
+// parseReactions is nil for a post nobody reacted to, like in MemStore
+func parseReactions(s string) (map[string]int, error) {
+	var reactions map[string]int
+	if err := json.Unmarshal([]byte(s), &reactions); err != nil || len(reactions) == 0 {
+		return nil, err
+	}
+	return reactions, nil
+}
+
+// Attachments are stored as a JSON array
+func formatAttachments(attachments []Attachment) string {
+	if len(attachments) == 0 {
+		return "[]"
+	}
+	data, _ := json.Marshal(attachments)
+	return string(data)
+}
+
+// Times are stored as RFC3339 text, which sorts correctly as a string
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// formatDeletedAt is NULL unless the post is in the trash
+func formatDeletedAt(p Post) sql.NullString {
+	if !p.Deleted || p.DeletedAt == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatTime(*p.DeletedAt), Valid: true}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	// wordsPerMinute is a typical adult reading speed
+	wordsPerMinute = 200
+
+	// excerptLength is how many characters of content the list shows
+	excerptLength = 160
+)
+
+// textPolicy drops every tag and keeps the text between them, with a space
+// where a tag was so the words either side of a <br> or </p> don't run together
+var textPolicy = bluemonday.StrictPolicy().AddSpaceWhenStrippingTag(true)
+
+// contentText is the content as a reader sees it, without tags and with
+// entities back to the characters they stand for, so that's what gets counted
+func contentText(content string) string {
+	return plainText(textPolicy.Sanitize(content))
+}
+
+// setReadingStats fills in the fields derived from the content. Stores call it
+// whenever content is written or read back, so the numbers never go stale.
+func setReadingStats(p *Post) {
+	p.WordCount = len(strings.Fields(contentText(p.Content)))
+	// Round up so a short post is a 1 minute read, an empty one stays at 0
+	p.ReadingTimeMinutes = (p.WordCount + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// excerpt shortens content to at most n characters (runes, not bytes) plus an ellipsis,
+// cutting at the last word boundary so no word is split. Whitespace is collapsed
+// since an excerpt is shown as a single line. It's cut from the text without
+// tags, so a tag is never cut in half, and escaped again like stored text.
+func excerpt(content string, n int) string {
+	text := []rune(strings.Join(strings.Fields(contentText(content)), " "))
+	if len(text) <= n {
+		return html.EscapeString(string(text))
+	}
+
+	cut := n
+	for cut > 0 && text[cut] != ' ' {
+		cut--
+	}
+	// A single word longer than n has to be cut somewhere
+	if cut == 0 {
+		cut = n
+	}
+	return html.EscapeString(strings.TrimRightFunc(string(text[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})) + "…"
+}
+
+// withExcerpts swaps each post's content for its excerpt, the way lists show posts
+func withExcerpts(posts []Post) {
+	for i := range posts {
+		posts[i].Excerpt = excerpt(posts[i].Content, excerptLength)
+		posts[i].Content = ""
+	}
+}
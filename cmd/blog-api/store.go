@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrPostExists is returned when creating a post with an ID that's already taken
+	ErrPostExists = errors.New("a post with this ID already exists")
+
+	// ErrPostNotFound is returned when updating a post that doesn't exist
+	ErrPostNotFound = errors.New("post not found")
+
+	// ErrVersionMismatch is returned when updating from a version that's no longer current
+	ErrVersionMismatch = errors.New("post version doesn't match")
+)
+
+// TitleTakenError is returned when creating a post titled like one that's
+// already there, with the unique titles setting on
+type TitleTakenError struct {
+	ID int // the post that has the title
+}
+
+func (e *TitleTakenError) Error() string {
+	return fmt.Sprintf("post %d already has this title", e.ID)
+}
+
+// sameTitle compares titles the way duplicates are detected, ignoring case and surrounding spaces
+func sameTitle(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// BatchError says which post of a CreateMany call failed
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("post %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// PostStore is everything the HTTP handlers need from a storage backend,
+// so the in-memory slice can later be swapped for a database. ctx is the caller's,
+// usually the request's, a backend that waits on I/O gives up once it's done.
+type PostStore interface {
+	List(ctx context.Context) []Post
+	Count(ctx context.Context, match func(Post) bool) int
+	Get(ctx context.Context, id int) (Post, bool)
+	GetBySlug(ctx context.Context, slug string) (Post, bool)
+	GetByUUID(ctx context.Context, uuid string) (Post, bool)
+	Create(ctx context.Context, p Post) (Post, error)
+	CreateMany(ctx context.Context, posts []Post) ([]Post, error)
+	Update(ctx context.Context, id int, p Post) (Post, error)
+	AppendContent(ctx context.Context, id int, content string) (Post, error)
+	AddAttachment(ctx context.Context, id int, att Attachment) (Post, error)
+	AddLikes(ctx context.Context, id, delta int) (int, error)
+	AddReaction(ctx context.Context, id int, emoji string) (map[string]int, error)
+	AddViews(ctx context.Context, id, n int) error
+	Delete(ctx context.Context, id int) bool
+}
+
+// MemStore keeps posts in a slice, guarded by a mutex since handlers run concurrently.
+// Nothing it does can block, so the contexts its methods take go unused.
+type MemStore struct {
+	mu     sync.RWMutex
+	posts  []Post
+	nextID int
+
+	// uniqueTitles makes Create refuse a title a post outside the trash already has
+	uniqueTitles bool
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{posts: []Post{}, nextID: 1}
+}
+
+// initializeSampleData wipes the store and seeds it with a couple of posts
+func initializeSampleData(s *MemStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.posts = samplePosts()
+	s.nextID = len(s.posts) + 1
+}
+
+// samplePosts is the starting data for a fresh store
+func samplePosts() []Post {
+	// Backdate the samples so they don't all show up with zero-value times
+	now := time.Now().UTC()
+	welcome := now.AddDate(0, 0, -7)
+	why := now.AddDate(0, 0, -2)
+
+	posts := []Post{
+		{ID: 1, Title: "Welcome to Go", Content: "Go is awesome for backend development!", Author: "Gopher", Slug: "welcome-to-go", Tags: []string{"go", "intro"}, Published: true, Version: 1, CreatedAt: welcome, UpdatedAt: welcome},
+		{ID: 2, Title: "Why Choose Go?", Content: "Fast, simple, and reliable.", Author: "Developer", Slug: "why-choose-go", Tags: []string{"go", "backend"}, Published: true, Version: 1, CreatedAt: why, UpdatedAt: why},
+	}
+	for i := range posts {
+		posts[i].UUID = uuid.NewString()
+		setReadingStats(&posts[i])
+	}
+	return posts
+}
+
+// Reset puts the sample data back, initializeSampleData takes the lock
+func (s *MemStore) Reset() error {
+	initializeSampleData(s)
+	return nil
+}
+
+// List returns a copy of all posts so callers can't touch the backing slice
+func (s *MemStore) List(_ context.Context) []Post {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Post{}, s.posts...)
+}
+
+// Count walks the posts under the read lock without copying them
+func (s *MemStore) Count(_ context.Context, match func(Post) bool) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, post := range s.posts {
+		if match(post) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *MemStore) Get(_ context.Context, id int) (Post, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, post := range s.posts {
+		if post.ID == id {
+			return post, true
+		}
+	}
+	return Post{}, false
+}
+
+func (s *MemStore) GetBySlug(_ context.Context, slug string) (Post, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, post := range s.posts {
+		if post.Slug == slug {
+			return post, true
+		}
+	}
+	return Post{}, false
+}
+
+func (s *MemStore) GetByUUID(_ context.Context, uuid string) (Post, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, post := range s.posts {
+		if post.UUID == uuid {
+			return post, true
+		}
+	}
+	return Post{}, false
+}
+
+// setSlug makes sure p has a slug no other post uses, called with the lock held
+func (s *MemStore) setSlug(p *Post) {
+	if p.Slug == "" {
+		p.Slug = slugify(p.Title)
+	}
+	p.Slug = uniqueSlug(p.Slug, func(slug string) bool {
+		for _, post := range s.posts {
+			if post.Slug == slug && post.ID != p.ID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Create stores the post with the next ID, or with its own ID when it has one.
+// A client-supplied ID moves nextID past it so auto IDs never collide with it.
+// The title is checked under the same lock, so two copies can't both get in.
+func (s *MemStore) Create(_ context.Context, p Post) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.taken(p.ID) {
+		return Post{}, ErrPostExists
+	}
+	if s.uniqueTitles {
+		for _, post := range s.posts {
+			if !post.Deleted && sameTitle(post.Title, p.Title) {
+				return Post{}, &TitleTakenError{ID: post.ID}
+			}
+		}
+	}
+	return s.add(p, time.Now().UTC()), nil
+}
+
+// CreateMany stores all the posts under one lock, or none of them when an ID is taken
+func (s *MemStore) CreateMany(_ context.Context, posts []Post) ([]Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createMany(posts)
+}
+
+// Seed replaces every post with the given ones, under one lock. They keep their
+// IDs and times when they have them, like an import. On error nothing changes.
+func (s *MemStore) Seed(_ context.Context, posts []Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, oldNext := s.posts, s.nextID
+	s.posts, s.nextID = []Post{}, 1
+	created, err := s.createMany(posts)
+	if err != nil {
+		s.posts, s.nextID = old, oldNext
+		return err
+	}
+	for i := range created {
+		s.posts[i].CreatedAt, s.posts[i].UpdatedAt = seedTimes(posts[i], created[i].CreatedAt)
+	}
+	return nil
+}
+
+// Restore replaces every post with the given ones exactly as they are, counters,
+// UUIDs and trash included, under one lock. Only the slugs missing are made up.
+func (s *MemStore) Restore(_ context.Context, posts []Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.posts, s.nextID = []Post{}, 1
+	for _, p := range posts {
+		s.setSlug(&p)
+		setReadingStats(&p)
+		p.Version = max(p.Version, 1)
+		p.Reactions = maps.Clone(p.Reactions)
+		s.posts = append(s.posts, p)
+		s.nextID = max(s.nextID, p.ID+1)
+	}
+	return nil
+}
+
+// seedTimes are the times a seeded post keeps, now for those it doesn't have
+func seedTimes(p Post, now time.Time) (time.Time, time.Time) {
+	created, updated := p.CreatedAt, p.UpdatedAt
+	if created.IsZero() {
+		created = now
+	}
+	if updated.Before(created) {
+		updated = created
+	}
+	return created.UTC(), updated.UTC()
+}
+
+// createMany is CreateMany with the lock held
+func (s *MemStore) createMany(posts []Post) ([]Post, error) {
+	// Check every ID first so a conflict leaves the store untouched
+	ids := make(map[int]bool)
+	for i, p := range posts {
+		if p.ID == 0 {
+			continue
+		}
+		if ids[p.ID] || s.taken(p.ID) {
+			return nil, &BatchError{Index: i, Err: ErrPostExists}
+		}
+		ids[p.ID] = true
+	}
+
+	now := time.Now().UTC()
+	created := make([]Post, len(posts))
+	for i, p := range posts {
+		// Skip IDs that a later post in the batch asked for
+		for p.ID == 0 && ids[s.nextID] {
+			s.nextID++
+		}
+		created[i] = s.add(p, now)
+	}
+	return created, nil
+}
+
+// taken reports whether id is already used, called with the lock held
+func (s *MemStore) taken(id int) bool {
+	for _, post := range s.posts {
+		if post.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends p, giving it the next ID when it has none, called with the lock held
+func (s *MemStore) add(p Post, now time.Time) Post {
+	if p.ID == 0 {
+		p.ID = s.nextID
+	}
+	if p.ID >= s.nextID {
+		s.nextID = p.ID + 1
+	}
+
+	p.UUID = uuid.NewString()
+	s.setSlug(&p)
+	setReadingStats(&p)
+	p.Version = 1
+	p.Likes, p.Views, p.Reactions = 0, 0, nil
+	p.Attachments = nil
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	s.posts = append(s.posts, p)
+	return p
+}
+
+// Update replaces a post, the IDs, counters and creation time are kept from the original.
+// p.Version must be the current version, so an update based on a stale read fails
+// with ErrVersionMismatch instead of overwriting someone else's changes.
+func (s *MemStore) Update(_ context.Context, id int, p Post) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, post := range s.posts {
+		if post.ID == id {
+			if p.Version != post.Version {
+				return Post{}, ErrVersionMismatch
+			}
+			p.ID = post.ID
+			p.UUID, p.Likes, p.Views, p.Reactions = post.UUID, post.Likes, post.Views, post.Reactions
+			p.Attachments = post.Attachments
+			p.Version++
+			s.setSlug(&p)
+			setReadingStats(&p)
+			p.CreatedAt = post.CreatedAt
+			p.UpdatedAt = time.Now().UTC()
+			s.posts[i] = p
+			return p, nil
+		}
+	}
+	return Post{}, ErrPostNotFound
+}
+
+// AppendContent adds a line of content to a post outside the trash under the lock,
+// so appends that come in together all make it. It's an edit, the version goes up.
+func (s *MemStore) AppendContent(_ context.Context, id int, content string) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.posts {
+		post := &s.posts[i]
+		if post.ID != id || post.Deleted {
+			continue
+		}
+		post.Content = joinContent(post.Content, content)
+		post.Version++
+		setReadingStats(post)
+		post.UpdatedAt = time.Now().UTC()
+		return *post, nil
+	}
+	return Post{}, ErrPostNotFound
+}
+
+// AddAttachment records an uploaded file on a post outside the trash under the
+// lock. Like an append it's an edit, the version goes up. The slice is replaced
+// rather than grown, posts already handed out share the old one.
+func (s *MemStore) AddAttachment(_ context.Context, id int, att Attachment) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.posts {
+		post := &s.posts[i]
+		if post.ID != id || post.Deleted {
+			continue
+		}
+		post.Attachments = append(slices.Clone(post.Attachments), att)
+		post.Version++
+		post.UpdatedAt = time.Now().UTC()
+		return *post, nil
+	}
+	return Post{}, ErrPostNotFound
+}
+
+// joinContent puts added content on a new line, unless there's nothing to follow
+func joinContent(content, added string) string {
+	if content == "" {
+		return added
+	}
+	return content + "\n" + added
+}
+
+// AddLikes changes a post's likes by delta under the lock, so concurrent likes
+// can't overwrite each other. The count never goes below zero.
+func (s *MemStore) AddLikes(_ context.Context, id, delta int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.posts {
+		if s.posts[i].ID == id {
+			s.posts[i].Likes = max(s.posts[i].Likes+delta, 0)
+			return s.posts[i].Likes, nil
+		}
+	}
+	return 0, ErrPostNotFound
+}
+
+// AddReaction counts one more of the emoji on a post under the lock. The map
+// is replaced rather than changed, posts already handed out share the old one.
+func (s *MemStore) AddReaction(_ context.Context, id int, emoji string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.posts {
+		if s.posts[i].ID == id {
+			reactions := maps.Clone(s.posts[i].Reactions)
+			if reactions == nil {
+				reactions = map[string]int{}
+			}
+			reactions[emoji]++
+			s.posts[i].Reactions = reactions
+			return maps.Clone(reactions), nil
+		}
+	}
+	return nil, ErrPostNotFound
+}
+
+func (s *MemStore) AddViews(_ context.Context, id, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.posts {
+		if s.posts[i].ID == id {
+			s.posts[i].Views += n
+			return nil
+		}
+	}
+	return ErrPostNotFound
+}
+
+// Delete holds the lock for the whole find-and-remove
+func (s *MemStore) Delete(_ context.Context, id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, post := range s.posts {
+		if post.ID == id {
+			s.posts = append(s.posts[:i], s.posts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// findPost is store.Get for posts that aren't in the trash, a deleted post
+// can't be edited or commented on until it's restored
+func (a *api) findPost(ctx context.Context, id int) (Post, bool) {
+	post, ok := a.store.Get(ctx, id)
+	if !ok || post.Deleted {
+		return Post{}, false
+	}
+	return post, true
+}
+
+// findVisiblePost is findPost for the routes anyone may read, drafts look like
+// they don't exist unless the request asks for them, see visible
+func (a *api) findVisiblePost(r *http.Request, id int) (Post, bool) {
+	post, ok := a.store.Get(r.Context(), id)
+	if !ok || !visible(post, r) {
+		return Post{}, false
+	}
+	return post, true
+}
+
+// getTrash lists the deleted posts, most recently deleted first
+func (a *api) getTrash(w http.ResponseWriter, r *http.Request) {
+	trash := []Post{}
+	for _, post := range a.store.List(r.Context()) {
+		if post.Deleted {
+			trash = append(trash, post)
+		}
+	}
+	sort.SliceStable(trash, func(i, j int) bool {
+		return trash[i].DeletedAt.After(*trash[j].DeletedAt)
+	})
+
+	writeJSON(w, r, http.StatusOK, trash)
+}
+
+// restorePost takes a post back out of the trash, as it was when deleted
+func (a *api) restorePost(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	post, ok := a.store.Get(r.Context(), id)
+	if !ok || !post.Deleted {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found in the trash")
+		return
+	}
+
+	post.Deleted, post.DeletedAt = false, nil
+	post, err = a.store.Update(r.Context(), id, post)
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
+	}
+	a.emit(r, eventUpdated, post)
+
+	setETag(w, post)
+	writeJSON(w, r, http.StatusOK, post)
+}
+
+// deletePermanently removes a post from the store along with its history,
+// whether it was in the trash or not
+func (a *api) deletePermanently(w http.ResponseWriter, r *http.Request) {
+	// Get ID from URL parameter
+	idStr := chi.URLParam(r, "id")
+	id, err := a.postID(r.Context(), idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidID, "Invalid post ID")
+		return
+	}
+
+	// Keep the post around for the event, Delete only says whether it existed
+	post, _ := a.store.Get(r.Context(), id)
+	if !a.store.Delete(r.Context(), id) {
+		writeError(w, http.StatusNotFound, codeNotFound, "Post not found")
+		return
+	}
+	a.revisions.Delete(id)
+	a.comments.DeletePost(id)
+	a.removeAttachments(post)
+
+	// Subscribers already heard about posts that went to the trash first,
+	// the audit log still records that they're gone for good
+	if !post.Deleted {
+		post.ID = id
+		a.emit(r, eventDeleted, post)
+	} else {
+		a.audit.record(r, auditPurged, id)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is one problem with one field of a post sent by a client
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// writeFieldErrors answers 422 with every problem found, so a form can point
+// out all of its bad fields at once
+func writeFieldErrors(w http.ResponseWriter, errs []FieldError) {
+	writeErrorBody(w, APIError{
+		Error:  "Some fields are invalid",
+		Code:   codeInvalidFields,
+		Status: http.StatusUnprocessableEntity,
+		Errors: errs,
+	})
+}
+
+// checkFields validates and sanitizes a post's text fields, collecting a
+// problem for each bad one. A nil field isn't being set and is skipped, an
+// empty one gets the empty message. Lengths are counted in characters on what
+// was sent, since escaping can make it longer, and a zero limit means no limit.
+func (a *api) checkFields(title, content, author *string, empty string) []FieldError {
+	fields := []struct {
+		name  string
+		value *string
+		max   int
+	}{
+		{"title", title, a.cfg.maxTitle},
+		{"content", content, a.cfg.maxContent},
+		{"author", author, 0},
+	}
+
+	var errs []FieldError
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		if f.max > 0 && utf8.RuneCountInString(*f.value) > f.max {
+			errs = append(errs, FieldError{Field: f.name, Message: fmt.Sprintf("must be at most %d characters", f.max)})
+			continue
+		}
+
+		// Sanitized before the empty check, so markup alone doesn't count
+		if a.sanitize != nil {
+			*f.value = a.sanitize(*f.value)
+		}
+		if *f.value == "" {
+			errs = append(errs, FieldError{Field: f.name, Message: empty})
+			continue
+		}
+
+		if f.name == "author" && !a.allowAuthor(f.value) {
+			errs = append(errs, FieldError{Field: f.name, Message: "must be one of " + strings.Join(a.cfg.authors, ", ")})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// viewBuffer is how many views can wait for the flusher before new ones are dropped
+	viewBuffer = 1024
+
+	// viewFlushInterval is how often counted views are written to the store
+	viewFlushInterval = time.Second
+
+	// defaultTrending is how many posts /posts/trending returns without ?limit=
+	defaultTrending = 10
+)
+
+// viewCounter counts post views off the request path. Handlers only drop the
+// post ID in a channel, a background goroutine adds them up and writes them to
+// the store in batches, so reads never wait on the store's write lock.
+type viewCounter struct {
+	store    PostStore
+	ids      chan int
+	interval time.Duration
+	done     chan struct{}
+
+	// closed is set by close, under mu so no view is sent on a closed channel
+	mu     sync.Mutex
+	closed bool
+}
+
+func newViewCounter(store PostStore) *viewCounter {
+	v := &viewCounter{
+		store:    store,
+		ids:      make(chan int, viewBuffer),
+		interval: viewFlushInterval,
+		done:     make(chan struct{}),
+	}
+	go v.run()
+	return v
+}
+
+// count records a view of the post. It never blocks, when the flusher falls
+// that far behind the view is dropped. A nil or closed counter counts nothing,
+// shutting down can give up on requests that are still running.
+func (v *viewCounter) count(id int) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.closed {
+		return
+	}
+	select {
+	case v.ids <- id:
+	default:
+		slog.Warn("dropping view, the counter is behind", "id", id)
+	}
+}
+
+// run adds up views until close, then writes what's still pending
+func (v *viewCounter) run() {
+	defer close(v.done)
+
+	ticker := time.NewTicker(v.interval)
+	defer ticker.Stop()
+
+	pending := map[int]int{}
+	for {
+		select {
+		case id, ok := <-v.ids:
+			if !ok {
+				v.flush(pending)
+				return
+			}
+			pending[id]++
+		case <-ticker.C:
+			v.flush(pending)
+		}
+	}
+}
+
+// flush writes the pending views to the store and empties the map
+func (v *viewCounter) flush(pending map[int]int) {
+	for id, n := range pending {
+		// The post may have been deleted since, its views go with it
+		if err := v.store.AddViews(context.Background(), id, n); err != nil && !errors.Is(err, ErrPostNotFound) {
+			slog.Error("saving views", "id", id, "err", err)
+		}
+		delete(pending, id)
+	}
+}
+
+// close stops counting and waits until every view counted so far is in the
+// store. Views counted after it are dropped.
+func (v *viewCounter) close() {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	if v.closed {
+		v.mu.Unlock()
+		return
+	}
+	v.closed = true
+	close(v.ids)
+	v.mu.Unlock()
+
+	<-v.done
+}
+
+// getTrending lists the most viewed posts, those nobody has viewed yet are left out
+func (a *api) getTrending(w http.ResponseWriter, r *http.Request) {
+	limit := queryInt(r, "limit", defaultTrending)
+	if limit == 0 {
+		limit = defaultTrending
+	}
+	limit = min(limit, maxLimit)
+
+	posts := []Post{}
+	for _, post := range a.store.List(r.Context()) {
+		if post.Views > 0 && post.Published && !post.Deleted {
+			posts = append(posts, post)
+		}
+	}
+	// Stable, so equally viewed posts stay in ID order
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Views > posts[j].Views
+	})
+	if len(posts) > limit {
+		posts = posts[:limit]
+	}
+
+	withExcerpts(posts)
+	writeJSON(w, r, http.StatusOK, posts)
+}
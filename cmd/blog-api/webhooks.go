@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhooks POSTs every event to each configured URL in the background,
+// a failed delivery is retried a couple of times and then only logged
+type webhooks struct {
+	urls    []string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+
+	// wg tracks deliveries still in flight, so shutdown can wait for them
+	wg sync.WaitGroup
+	// stop is canceled when shutdown is done waiting, deliveries still going give up
+	stop   context.Context
+	cancel context.CancelFunc
+}
+
+// newWebhooks returns nil when there are no URLs, a nil *webhooks sends nothing
+func newWebhooks(urls []string) *webhooks {
+	if len(urls) == 0 {
+		return nil
+	}
+	stop, cancel := context.WithCancel(context.Background())
+	return &webhooks{
+		urls:    urls,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		retries: 2,
+		backoff: time.Second,
+		stop:    stop,
+		cancel:  cancel,
+	}
+}
+
+// send queues e for every URL and returns right away. ctx is the request the
+// change came from, see deliveryContext.
+func (h *webhooks) send(ctx context.Context, e event) {
+	if h == nil {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.ErrorContext(ctx, "encoding webhook event", "event", e.Event, "err", err)
+		return
+	}
+
+	for _, url := range h.urls {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			ctx, cancel := h.deliveryContext(ctx)
+			defer cancel()
+			h.deliver(ctx, url, e.Event, body)
+		}()
+	}
+}
+
+// deliveryContext keeps ctx's values, so the logs still show the request ID,
+// but not its cancelation: deliveries go on after the response is sent.
+// Only shutdown cuts them short.
+func (h *webhooks) deliveryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(h.stop, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// deliver tries a few times, waiting a little longer after each failure
+func (h *webhooks) deliver(ctx context.Context, url, name string, body []byte) {
+	var err error
+	for attempt := 0; attempt <= h.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * h.backoff):
+			case <-ctx.Done():
+				slog.WarnContext(ctx, "webhook delivery canceled", "url", url, "event", name, "attempts", attempt, "err", err)
+				return
+			}
+		}
+		if err = h.post(ctx, url, body); err == nil {
+			return
+		}
+	}
+	slog.WarnContext(ctx, "webhook delivery failed", "url", url, "event", name, "attempts", h.retries+1, "err", err)
+}
+
+func (h *webhooks) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// wait blocks until queued deliveries are done or ctx runs out, then cancels
+// the ones left
+func (h *webhooks) wait(ctx context.Context) {
+	if h == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("shutting down with webhook deliveries still pending")
+	}
+	h.cancel()
+}